@@ -1,97 +1,177 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"go-http/internal/headers"
+	"go-http/internal/fileserver"
 	"go-http/internal/request"
 	"go-http/internal/response"
+	"go-http/internal/router"
 	"go-http/internal/server"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"strings"
+	"strconv"
 	"syscall"
+	"time"
 )
 
 const defaultPort = 42069
+const shutdownTimeout = 10 * time.Second
 
-func badRequest() string {
-	return `<html>
-    <head>
-    <title>400 Bad Request</title>
-    </head>
-    <body>
-    <h1>Bad Request</h1>
-    <p>Your request honestly kinda sucked.</p>
-    </body>
-    </html>`
+var errorMessages = map[response.StatusCode]string{
+	response.HTTP_STATUS_BAD_REQUEST:           "Your request honestly kinda sucked.",
+	response.HTTP_STATUS_INTERNAL_SERVER_ERROR: "Okay, you know what? This one is on me.",
 }
 
-func serverError() string {
-	return `<html>
-  <head>
-    <title>500 Internal Server Error</title>
-  </head>
-  <body>
-    <h1>Internal Server Error</h1>
-    <p>Okay, you know what? This one is on me.</p>
-  </body>
-</html>`
+// errorProblem answers every error status the server or a handler sends
+// with RFC 9457 problem details.
+func errorProblem(res response.ResponseWriter, req *request.Request, statusCode response.StatusCode) {
+	problem := response.NewProblem(statusCode, errorMessages[statusCode])
+
+	if req != nil {
+		problem.Instance = req.URL.RawPath
+	}
+
+	response.SendProblem(res, problem)
 }
 
 func main() {
 	port := flag.Int("port", defaultPort, "port")
+	staticDir := flag.String("static", "", "directory served under /static/")
 
 	flag.Parse()
 
-	server, err := server.Serve(*port, func(res response.ResponseWriter, req *request.Request) {
-		target := req.RequestLine.RequestTarget
+	routes := router.New()
+
+	routes.Use(server.Logging, server.DecodeBody(10<<20), server.Compress(server.DefaultCompressConfig()))
+
+	routes.NotFound = func(res response.ResponseWriter, req *request.Request) {
+		errorProblem(res, req, response.HTTP_STATUS_NOT_FOUND)
+	}
+
+	routes.MethodNotAllowed = func(res response.ResponseWriter, req *request.Request) {
+		errorProblem(res, req, response.HTTP_STATUS_METHOD_NOT_ALLOWED)
+	}
+
+	routes.Post("/echo", func(res response.ResponseWriter, req *request.Request) {
+		var message struct {
+			Text string `json:"text"`
+		}
+
+		if err := request.DecodeJSON(req, &message); err != nil {
+			status := response.HTTP_STATUS_BAD_REQUEST
+
+			switch {
+			case errors.Is(err, request.ERROR_NOT_JSON):
+				status = response.HTTP_STATUS_UNSUPPORTED_MEDIA_TYPE
+			case errors.Is(err, request.ERROR_BODY_TOO_LARGE):
+				status = response.HTTP_STATUS_CONTENT_TOO_LARGE
+			}
+
+			response.SendProblem(res, response.NewProblem(status, err.Error()))
+			return
+		}
+
+		response.JSON(res, response.HTTP_STATUS_OK, message)
+	})
+
+	routes.Get("/yourproblem", func(res response.ResponseWriter, req *request.Request) {
+		errorProblem(res, req, response.HTTP_STATUS_BAD_REQUEST)
+	})
+
+	// the server recovers and answers with the 500 error page
+	routes.Get("/myproblem", func(res response.ResponseWriter, req *request.Request) {
+		panic("this one is on me")
+	})
 
-		switch {
-		case target == "/yourproblem":
-			hdrs := headers.NewHeaders()
+	routes.Get("/httpbin/stream/{num}", func(res response.ResponseWriter, req *request.Request) {
+		num := url.PathEscape(req.PathValue("num"))
 
-			hdrs.Set("Content-Type", "text/html")
+		resp, err := http.Get(fmt.Sprintf("https://httpbin.org/stream/%s", num))
 
-			res.Send(response.HTTP_STATUS_BAD_REQUEST, *hdrs, []byte(badRequest()))
+		if err != nil {
+			response.SendBodyWithDefaultHeaders(
+				res,
+				response.HTTP_STATUS_INTERNAL_SERVER_ERROR,
+				[]byte("Internal server error"),
+			)
+			return
+		}
 
-		case target == "/myproblem":
-			hdrs := headers.NewHeaders()
+		response.SendFromStream(res, response.HTTP_STATUS_OK, resp.Body)
+	})
 
-			hdrs.Set("Content-Type", "text/html")
+	// a server-sent event with the time every second, numbered so that a
+	// reconnecting EventSource carries on from Last-Event-ID
+	routes.Get("/clock", func(res response.ResponseWriter, req *request.Request) {
+		stream, err := response.NewEventStream(res)
+
+		if err != nil {
+			return
+		}
 
-			res.Send(response.HTTP_STATUS_INTERNAL_SERVER_ERROR, *hdrs, []byte(serverError()))
+		defer stream.Close()
+		stream.KeepAlive(15 * time.Second)
 
-		case strings.HasPrefix(target, "/httpbin/stream/"):
-			num := strings.Replace(target, "/httpbin/stream/", "", 1)
+		id, _ := strconv.Atoi(req.LastEventID())
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
 
-			resp, err := http.Get(fmt.Sprintf("https://httpbin.org/stream/%s", num))
+		for {
+			select {
+			case now := <-ticker.C:
+				id++
+				stream.Send(response.Event{ID: strconv.Itoa(id), Event: "tick", Data: now.Format(time.RFC3339)})
 
-			if err != nil {
-				res.SendBodyWithDefaultHeaders(
-					response.HTTP_STATUS_INTERNAL_SERVER_ERROR,
-					[]byte("Internal server error"),
-				)
+			case <-stream.Done():
 				return
-			}
 
-			res.SendFromStream(response.HTTP_STATUS_OK, resp.Body)
+			case <-req.Context().Done():
+				return
 
-		default:
-			res.SendEmptyResponse(response.HTTP_STATUS_OK)
+			case <-req.ServerDone():
+				return
+			}
 		}
 	})
 
+	if *staticDir != "" {
+		files := fileserver.New(os.DirFS(*staticDir))
+		files.Prefix = "/static"
+		files.ListDirectories = true
+
+		routes.Get("/static/{path...}", files.ServeHTTP)
+	}
+
+	routes.Get("/{path...}", func(res response.ResponseWriter, req *request.Request) {
+		response.SendEmptyResponse(res, response.HTTP_STATUS_OK)
+	})
+
+	config := server.DefaultConfig()
+	config.ErrorHandler = errorProblem
+
+	server, err := server.ServeWithConfig(*port, routes.ServeHTTP, config)
+
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	log.Println("Server started on port", *port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatalf("Server forced to stop: %v", err)
+	}
+
 	log.Println("Server gracefully stopped")
 }
@@ -25,8 +25,10 @@ func handleConnection(conn net.Conn) {
 
 	fmt.Println("Headers:")
 
-	for key, value := range req.Headers.GetHeaders() {
-		fmt.Printf("- %s: %s \n", key, value)
+	for _, key := range req.Headers.Names() {
+		for _, value := range req.Headers.Values(key) {
+			fmt.Printf("- %s: %s \n", key, value)
+		}
 	}
 
 	// fmt.Printf("Body: %s", string(req.Body))
@@ -0,0 +1,190 @@
+package cookie
+
+import (
+	"fmt"
+	"go-http/internal/headers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = 0 // attribute left out
+	SameSiteLax     SameSite = 1
+	SameSiteStrict  SameSite = 2
+	SameSiteNone    SameSite = 3
+)
+
+var ERROR_INVALID_NAME = fmt.Errorf("Invalid cookie name")
+var ERROR_INVALID_VALUE = fmt.Errorf("Invalid cookie value")
+var ERROR_INVALID_PATH = fmt.Errorf("Invalid cookie path")
+var ERROR_INVALID_DOMAIN = fmt.Errorf("Invalid cookie domain")
+var ERROR_INVALID_EXPIRES = fmt.Errorf("Invalid cookie expiry date")
+var ERROR_INSECURE = fmt.Errorf("SameSite=None and Partitioned cookies must be Secure")
+
+// Cookie is an HTTP cookie (RFC 6265). Requests only carry Name and
+// Value, the other fields are attributes of Set-Cookie.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge is the lifetime in seconds. Zero leaves Max-Age out, a
+	// negative value deletes the cookie right away.
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Parse parses the value of a Cookie request header. Malformed pairs are
+// skipped, as browsers send what they were given.
+func Parse(value string) []*Cookie {
+	var cookies []*Cookie
+
+	for _, pair := range strings.Split(value, ";") {
+		name, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+
+		if !found || !isValidName(name) {
+			continue
+		}
+
+		if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+			val = val[1 : len(val)-1]
+		}
+
+		if !isValidValue(val) {
+			continue
+		}
+
+		cookies = append(cookies, &Cookie{Name: name, Value: val})
+	}
+
+	return cookies
+}
+
+// Valid checks the cookie can be sent in a Set-Cookie header.
+func (c *Cookie) Valid() error {
+	if !isValidName(c.Name) {
+		return ERROR_INVALID_NAME
+	}
+
+	if !isValidValue(c.Value) {
+		return ERROR_INVALID_VALUE
+	}
+
+	if strings.ContainsFunc(c.Path, func(ch rune) bool { return ch < 0x20 || ch == 0x7f || ch == ';' }) {
+		return ERROR_INVALID_PATH
+	}
+
+	if c.Domain != "" && !isValidDomain(strings.TrimPrefix(c.Domain, ".")) {
+		return ERROR_INVALID_DOMAIN
+	}
+
+	if !c.Expires.IsZero() && c.Expires.UTC().Year() < 1601 {
+		return ERROR_INVALID_EXPIRES
+	}
+
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		return ERROR_INSECURE
+	}
+
+	return nil
+}
+
+// String formats the cookie as a Set-Cookie header value. It does not
+// validate, see Valid.
+func (c *Cookie) String() string {
+	var builder strings.Builder
+
+	builder.WriteString(c.Name + "=" + c.Value)
+
+	if c.Path != "" {
+		builder.WriteString("; Path=" + c.Path)
+	}
+
+	if c.Domain != "" {
+		builder.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+
+	if !c.Expires.IsZero() {
+		builder.WriteString("; Expires=" + c.Expires.UTC().Format(headers.TIME_FORMAT))
+	}
+
+	if c.MaxAge > 0 {
+		builder.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		builder.WriteString("; Max-Age=0")
+	}
+
+	if c.HttpOnly {
+		builder.WriteString("; HttpOnly")
+	}
+
+	if c.Secure {
+		builder.WriteString("; Secure")
+	}
+
+	switch c.SameSite {
+	case SameSiteLax:
+		builder.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		builder.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		builder.WriteString("; SameSite=None")
+	}
+
+	if c.Partitioned {
+		builder.WriteString("; Partitioned")
+	}
+
+	return builder.String()
+}
+
+func isValidName(name string) bool {
+	return name != "" && headers.IsValidToken([]byte(name))
+}
+
+// isValidValue checks for cookie-octets, optionally in double quotes.
+func isValidValue(value string) bool {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+
+		if ch <= 0x20 || ch >= 0x7f || ch == '"' || ch == ',' || ch == ';' || ch == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isValidDomain(domain string) bool {
+	if domain == "" || len(domain) > 255 {
+		return false
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for i := 0; i < len(label); i++ {
+			ch := label[i]
+
+			if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,55 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cookies := Parse(`session=abc123; theme="dark"; bad name=x; noequals; empty=; a=b,c`)
+
+	assert.Equal(t, []*Cookie{
+		{Name: "session", Value: "abc123"},
+		{Name: "theme", Value: "dark"},
+		{Name: "empty", Value: ""},
+	}, cookies)
+}
+
+func TestString(t *testing.T) {
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.test",
+		Expires:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+
+	assert.NoError(t, c.Valid())
+	assert.Equal(t,
+		"session=abc123; Path=/; Domain=example.test; Expires=Wed, 02 Jan 2030 03:04:05 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=None; Partitioned",
+		c.String())
+
+	// Test: negative MaxAge deletes the cookie
+	c = &Cookie{Name: "session", MaxAge: -1, SameSite: SameSiteLax}
+	assert.Equal(t, "session=; Max-Age=0; SameSite=Lax", c.String())
+}
+
+func TestValid(t *testing.T) {
+	assert.ErrorIs(t, (&Cookie{Name: ""}).Valid(), ERROR_INVALID_NAME)
+	assert.ErrorIs(t, (&Cookie{Name: "a b"}).Valid(), ERROR_INVALID_NAME)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Value: "x;y"}).Valid(), ERROR_INVALID_VALUE)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Value: "x y"}).Valid(), ERROR_INVALID_VALUE)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Path: "/x;Secure"}).Valid(), ERROR_INVALID_PATH)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Domain: "exa mple.test"}).Valid(), ERROR_INVALID_DOMAIN)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)}).Valid(), ERROR_INVALID_EXPIRES)
+	assert.ErrorIs(t, (&Cookie{Name: "a", SameSite: SameSiteNone}).Valid(), ERROR_INSECURE)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Partitioned: true}).Valid(), ERROR_INSECURE)
+	assert.NoError(t, (&Cookie{Name: "a", Value: `"quoted"`}).Valid())
+}
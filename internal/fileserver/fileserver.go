@@ -0,0 +1,271 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"go-http/internal/headers"
+	"go-http/internal/request"
+	"go-http/internal/response"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const indexFile = "index.html"
+
+// FileServer serves the files of an fs.FS. Directories are answered with
+// their index.html, or an HTML listing when ListDirectories is set.
+//
+// Request paths are mapped to names in Root after removing Prefix, so a
+// FileServer with Prefix "/static" serves "/static/css/app.css" from
+// "css/app.css".
+type FileServer struct {
+	Root            fs.FS
+	Prefix          string
+	ListDirectories bool
+}
+
+func New(root fs.FS) *FileServer {
+	return &FileServer{Root: root}
+}
+
+// ServeHTTP is the server.Handler serving the file for the request path.
+func (f *FileServer) ServeHTTP(w response.ResponseWriter, req *request.Request) {
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		sendStatus(w, response.HTTP_STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	rest, found := strings.CutPrefix(req.URL.Path, f.Prefix)
+
+	if !found || rest != "" && !strings.HasPrefix(rest, "/") && !strings.HasSuffix(f.Prefix, "/") {
+		sendStatus(w, response.HTTP_STATUS_NOT_FOUND)
+		return
+	}
+
+	name, ok := fsName(rest)
+
+	if !ok {
+		sendStatus(w, response.HTTP_STATUS_BAD_REQUEST)
+		return
+	}
+
+	info, err := fs.Stat(f.Root, name)
+
+	if err != nil {
+		sendStatus(w, errorStatus(err))
+		return
+	}
+
+	if info.IsDir() {
+		// relative links in the index and listing need the trailing slash
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			redirect(w, req, req.URL.RawPath+"/")
+			return
+		}
+
+		index := path.Join(name, indexFile)
+
+		if indexInfo, err := fs.Stat(f.Root, index); err == nil && !indexInfo.IsDir() {
+			f.serveFile(w, req, index, indexInfo)
+			return
+		}
+
+		if !f.ListDirectories {
+			sendStatus(w, response.HTTP_STATUS_NOT_FOUND)
+			return
+		}
+
+		f.serveListing(w, name)
+		return
+	}
+
+	f.serveFile(w, req, name, info)
+}
+
+func (f *FileServer) serveFile(w response.ResponseWriter, req *request.Request, name string, info fs.FileInfo) {
+	file, err := f.Root.Open(name)
+
+	if err != nil {
+		sendStatus(w, errorStatus(err))
+		return
+	}
+
+	defer file.Close()
+
+	content, ok := file.(io.ReadSeeker)
+	etag := ""
+
+	// files without a modification time, like the ones of embed.FS, are
+	// read whole and identified by their hash
+	if !ok || info.ModTime().IsZero() {
+		data, err := io.ReadAll(file)
+
+		if err != nil {
+			sendStatus(w, response.HTTP_STATUS_INTERNAL_SERVER_ERROR)
+			return
+		}
+
+		sum := sha256.Sum256(data)
+		content = bytes.NewReader(data)
+		etag = fmt.Sprintf(`"%x"`, sum[:8])
+	} else {
+		etag = fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(headers.TIME_FORMAT))
+	}
+
+	w.Header().Set("ETag", etag)
+
+	if notModified(req, etag, info.ModTime()) {
+		w.WriteHeader(response.HTTP_STATUS_NOT_MODIFIED)
+		return
+	}
+
+	contentType, err := detectContentType(name, content)
+
+	if err != nil {
+		sendStatus(w, response.HTTP_STATUS_INTERNAL_SERVER_ERROR)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	response.SendContent(w, &req.Headers, content)
+}
+
+func (f *FileServer) serveListing(w response.ResponseWriter, name string) {
+	entries, err := fs.ReadDir(f.Root, name)
+
+	if err != nil {
+		sendStatus(w, errorStatus(err))
+		return
+	}
+
+	title := html.EscapeString("/" + strings.TrimPrefix(name, "."))
+
+	var page strings.Builder
+
+	fmt.Fprintf(&page, "<html>\n  <head>\n    <title>%s</title>\n  </head>\n  <body>\n    <h1>%s</h1>\n    <ul>\n", title, title)
+
+	for _, entry := range entries {
+		entryName := entry.Name()
+
+		if entry.IsDir() {
+			entryName += "/"
+		}
+
+		link := (&url.URL{Path: entryName}).EscapedPath()
+
+		// "./" keeps names with a colon from reading as a scheme
+		fmt.Fprintf(&page, "      <li><a href=\"./%s\">%s</a></li>\n", html.EscapeString(link), html.EscapeString(entryName))
+	}
+
+	page.WriteString("    </ul>\n  </body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	response.SendBodyWithDefaultHeaders(w, response.HTTP_STATUS_OK, []byte(page.String()))
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is
+// no If-None-Match (RFC 9110 13.2.2).
+func notModified(req *request.Request, etag string, modTime time.Time) bool {
+	if req.Headers.Contains("If-None-Match") {
+		for _, candidate := range strings.Split(req.Headers.Get("If-None-Match"), ",") {
+			candidate = strings.TrimSpace(candidate)
+
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if modTime.IsZero() || !req.Headers.Contains("If-Modified-Since") {
+		return false
+	}
+
+	since, err := time.Parse(headers.TIME_FORMAT, req.Headers.Get("If-Modified-Since"))
+
+	return err == nil && !modTime.Truncate(time.Second).After(since)
+}
+
+// detectContentType picks the MIME type from the extension of name, and
+// falls back to looking at the start of content.
+func detectContentType(name string, content io.ReadSeeker) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	head = head[:n]
+
+	// a cut may split the last rune
+	for i := 0; i < utf8.UTFMax && len(head) == 512 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+
+	if utf8.Valid(head) && !bytes.ContainsRune(head, 0) {
+		return "text/plain; charset=utf-8", nil
+	}
+
+	return "application/octet-stream", nil
+}
+
+// fsName turns a decoded request path into an fs.FS name. It reports false
+// for paths trying to leave the root.
+func fsName(requestPath string) (string, bool) {
+	for _, seg := range strings.Split(requestPath, "/") {
+		if seg == ".." || strings.ContainsRune(seg, '\\') {
+			return "", false
+		}
+	}
+
+	name := strings.Trim(path.Clean("/"+requestPath), "/")
+
+	if name == "" {
+		name = "."
+	}
+
+	return name, fs.ValidPath(name)
+}
+
+func errorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return response.HTTP_STATUS_NOT_FOUND
+	case errors.Is(err, fs.ErrPermission):
+		return response.HTTP_STATUS_FORBIDDEN
+	case errors.Is(err, fs.ErrInvalid):
+		return response.HTTP_STATUS_BAD_REQUEST
+	default:
+		return response.HTTP_STATUS_INTERNAL_SERVER_ERROR
+	}
+}
+
+func redirect(w response.ResponseWriter, req *request.Request, location string) {
+	if req.URL.RawQuery != "" {
+		location += "?" + req.URL.RawQuery
+	}
+
+	w.Header().Set("Location", location)
+	sendStatus(w, response.HTTP_STATUS_MOVED_PERMANENTLY)
+}
+
+func sendStatus(w response.ResponseWriter, statusCode response.StatusCode) {
+	response.SendBodyWithDefaultHeaders(w, statusCode, []byte(response.StatusText(statusCode)))
+}
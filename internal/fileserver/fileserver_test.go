@@ -0,0 +1,163 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"go-http/internal/request"
+	"go-http/internal/response"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":        {Data: []byte("<h1>home</h1>"), ModTime: modTime},
+		"css/app.css":       {Data: []byte("body {}"), ModTime: modTime},
+		"docs/readme":       {Data: []byte("plain text"), ModTime: modTime},
+		"docs/a <b>.txt":    {Data: []byte("odd name"), ModTime: modTime},
+		"bin/blob":          {Data: []byte{0, 1, 2, 3}, ModTime: modTime},
+		"embedded/data.txt": {Data: []byte("no mod time")},
+	}
+}
+
+func serve(t *testing.T, f *FileServer, method, target string, extra ...string) (*http.Response, string) {
+	head := method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+
+	for _, line := range extra {
+		head += line + "\r\n"
+	}
+
+	req, err := request.RequestFromReader(strings.NewReader(head + "\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	w := response.NewResponseWriter(buf)
+
+	if method == "HEAD" {
+		w.SetHeadOnly()
+	}
+
+	f.ServeHTTP(w, req)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), &http.Request{Method: method})
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func TestServeFiles(t *testing.T) {
+	f := New(testFS())
+
+	// Test: MIME type, ETag and Last-Modified
+	resp, body := serve(t, f, "GET", "/css/app.css")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "body {}", body)
+	assert.Equal(t, "text/css; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", resp.Header.Get("Last-Modified"))
+	assert.NotEmpty(t, resp.Header.Get("ETag"))
+	etag := resp.Header.Get("ETag")
+
+	// Test: content sniffing without a known extension
+	resp, _ = serve(t, f, "GET", "/docs/readme")
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	resp, _ = serve(t, f, "GET", "/bin/blob")
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+
+	// Test: index.html for directories, redirect to the trailing slash
+	resp, body = serve(t, f, "GET", "/")
+	assert.Equal(t, "<h1>home</h1>", body)
+
+	resp, _ = serve(t, f, "GET", "/docs?x=1")
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "/docs/?x=1", resp.Header.Get("Location"))
+
+	// Test: HEAD sends headers only
+	resp, body = serve(t, f, "HEAD", "/css/app.css")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int64(7), resp.ContentLength)
+	assert.Equal(t, "", body)
+
+	// Test: ranges
+	resp, body = serve(t, f, "GET", "/css/app.css", "Range: bytes=0-3")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "bytes 0-3/7", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "body", body)
+
+	// Test: conditional requests
+	resp, body = serve(t, f, "GET", "/css/app.css", "If-None-Match: \"other\", W/"+etag)
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, "", body)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+
+	resp, _ = serve(t, f, "GET", "/css/app.css", "If-None-Match: \"other\"")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, _ = serve(t, f, "GET", "/css/app.css", "If-Modified-Since: Wed, 01 May 2024 12:00:00 GMT")
+	assert.Equal(t, 304, resp.StatusCode)
+
+	resp, _ = serve(t, f, "GET", "/css/app.css", "If-Modified-Since: Tue, 30 Apr 2024 12:00:00 GMT")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: files without a modification time get a content hash ETag
+	resp, body = serve(t, f, "GET", "/embedded/data.txt")
+	assert.Equal(t, "no mod time", body)
+	assert.Equal(t, "", resp.Header.Get("Last-Modified"))
+	assert.NotEmpty(t, resp.Header.Get("ETag"))
+
+	// Test: errors
+	resp, _ = serve(t, f, "GET", "/missing.txt")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, _ = serve(t, f, "GET", "/docs/")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, _ = serve(t, f, "POST", "/index.html")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+
+	resp, _ = serve(t, f, "GET", "/docs/..%5C..%5Cetc")
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestDirectoryListing(t *testing.T) {
+	f := New(testFS())
+	f.ListDirectories = true
+
+	resp, body := serve(t, f, "GET", "/docs/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, `<a href="./readme">readme</a>`)
+	assert.Contains(t, body, `<a href="./a%20%3Cb%3E.txt">a &lt;b&gt;.txt</a>`)
+
+	resp, body = serve(t, f, "GET", "/")
+	assert.Equal(t, "<h1>home</h1>", body)
+}
+
+func TestPrefix(t *testing.T) {
+	f := New(testFS())
+	f.Prefix = "/static"
+
+	_, body := serve(t, f, "GET", "/static/css/app.css")
+	assert.Equal(t, "body {}", body)
+
+	resp, _ := serve(t, f, "GET", "/staticcss/app.css")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, _ = serve(t, f, "GET", "/static")
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "/static/", resp.Header.Get("Location"))
+}
@@ -3,44 +3,117 @@ package headers
 import (
 	"bytes"
 	"fmt"
-	"maps"
+	"slices"
 	"strconv"
 	"strings"
 )
 
+// Headers holds header fields keyed by case-insensitive name. Every name
+// keeps its values in the order they were added, and names keep the order
+// they were first seen in.
 type Headers struct {
-	headers map[string]string
+	names  []string
+	values map[string][]string
 }
 
+// Get returns all values of key combined into one comma separated value,
+// as in RFC 9110 5.3. Use Values for fields like Set-Cookie that can't be
+// combined.
 func (h *Headers) Get(key string) string {
-	return h.headers[strings.ToLower(key)]
+	return strings.Join(h.values[strings.ToLower(key)], ", ")
 }
 
+func (h *Headers) Values(key string) []string {
+	return h.values[strings.ToLower(key)]
+}
+
+// Set replaces all values of key with value.
 func (h *Headers) Set(key string, value string) {
-	h.headers[strings.ToLower(key)] = value
+	h.Del(key)
+	h.Add(key, value)
+}
+
+// Add appends value to the values of key.
+func (h *Headers) Add(key string, value string) {
+	name := strings.ToLower(key)
+
+	if h.values == nil {
+		h.values = map[string][]string{}
+	}
+
+	if _, ok := h.values[name]; !ok {
+		h.names = append(h.names, name)
+	}
+
+	h.values[name] = append(h.values[name], value)
 }
 
 func (h *Headers) Contains(key string) bool {
-	return h.headers[strings.ToLower(key)] != ""
+	return len(h.values[strings.ToLower(key)]) > 0
+}
+
+// HasToken reports whether the comma separated list stored under key
+// contains token, compared case-insensitively (e.g. "Connection: close").
+func (h *Headers) HasToken(key, token string) bool {
+	for _, value := range h.Values(key) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
+// Extend replaces the values of every name in headers.
 func (h *Headers) Extend(headers Headers) {
-	for k := range maps.Keys(headers.GetHeaders()) {
-		h.Set(k, headers.Get(k))
+	for _, name := range headers.names {
+		h.Del(name)
+
+		for _, value := range headers.values[name] {
+			h.Add(name, value)
+		}
 	}
 }
 
+// Names returns the lowercase field names in order.
+func (h Headers) Names() []string {
+	return slices.Clone(h.names)
+}
+
+// GetHeaders returns every field with its values combined, see Get.
 func (h Headers) GetHeaders() map[string]string {
-	return h.headers
+	headers := map[string]string{}
+
+	for _, name := range h.names {
+		headers[name] = h.Get(name)
+	}
+
+	return headers
 }
 
-func (h *Headers) Delete(key string) {
-	delete(h.headers, strings.ToLower(key))
+func (h *Headers) Del(key string) {
+	name := strings.ToLower(key)
+
+	if _, ok := h.values[name]; !ok {
+		return
+	}
+
+	delete(h.values, name)
+	h.names = slices.DeleteFunc(h.names, func(n string) bool { return n == name })
+}
+
+func (h Headers) Clone() Headers {
+	clone := Headers{}
+	clone.Extend(h)
+
+	return clone
 }
 
 func NewHeaders() *Headers {
 	return &Headers{
-		headers: map[string]string{},
+		values: map[string][]string{},
 	}
 }
 
@@ -48,25 +121,71 @@ func GetDefaultHeaders(contentLen int) Headers {
 	headers := NewHeaders()
 
 	headers.Set("Content-Length", strconv.Itoa(contentLen))
-	headers.Set("Connection", "close")
 	headers.Set("Content-Type", "text/plain")
 
 	return *headers
 }
 
+// ToString writes every value on its own field line, in order and with
+// canonical name casing, followed by the empty line ending the section.
 func (h Headers) ToString() string {
-	headersString := ""
+	var builder strings.Builder
 
-	for key, value := range h.headers {
-		headersString += fmt.Sprintf("%s: %s\r\n", key, value)
+	for _, name := range h.names {
+		canonical := CanonicalName(name)
+
+		for _, value := range h.values[name] {
+			builder.WriteString(canonical)
+			builder.WriteString(": ")
+			builder.WriteString(sanitizeValue(value))
+			builder.WriteString(CRLF)
+		}
 	}
 
-	headersString += "\r\n"
+	builder.WriteString(CRLF)
+
+	return builder.String()
+}
+
+// CanonicalName returns name with the first letter and every letter after
+// a hyphen upper-cased, e.g. "content-type" becomes "Content-Type".
+func CanonicalName(name string) string {
+	if !IsValidToken([]byte(name)) {
+		return name
+	}
+
+	canonical := []byte(strings.ToLower(name))
+	upper := true
+
+	for i, ch := range canonical {
+		if upper && ch >= 'a' && ch <= 'z' {
+			canonical[i] = ch - 'a' + 'A'
+		}
+
+		upper = ch == '-'
+	}
 
-	return headersString
+	return string(canonical)
 }
 
-func isValidToken(key []byte) bool {
+// sanitizeValue replaces line breaks and NUL in a field value so a value
+// can never start a new field line.
+func sanitizeValue(value string) string {
+	if !strings.ContainsAny(value, "\r\n\x00") {
+		return value
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return ' '
+		}
+
+		return r
+	}, value)
+}
+
+// IsValidToken reports whether key is a valid RFC 9110 token.
+func IsValidToken(key []byte) bool {
 	for _, ch := range key {
 		present := false
 
@@ -89,6 +208,10 @@ func isValidToken(key []byte) bool {
 
 var CRLF = "\r\n"
 
+// TIME_FORMAT is the IMF-fixdate format of HTTP dates like Last-Modified
+// and Expires (RFC 9110 5.6.7). Times must be in UTC.
+const TIME_FORMAT = "Mon, 02 Jan 2006 15:04:05 GMT"
+
 var MALFORMED_FIELD_LINE = fmt.Errorf("Malformed field line")
 var MALFORMED_FIELD_NAME = fmt.Errorf("Malformed field name")
 
@@ -99,6 +222,11 @@ func parseHeader(fieldLine []byte) (string, string, error) {
 		return "", "", MALFORMED_FIELD_LINE
 	}
 
+	// a bare CR or LF may end the line for another parser, RFC 9112 5.5
+	if bytes.ContainsAny(fieldLine, "\r\n\x00") {
+		return "", "", MALFORMED_FIELD_LINE
+	}
+
 	fieldName := fieldLine[:colonIdx]
 	fieldValue := bytes.TrimSpace(fieldLine[colonIdx+len([]byte(":")):])
 
@@ -109,7 +237,7 @@ func parseHeader(fieldLine []byte) (string, string, error) {
 	return string(fieldName), string(fieldValue), nil
 }
 
-func (h Headers) Parse(data []byte) (int, bool, error) {
+func (h *Headers) Parse(data []byte) (int, bool, error) {
 	read := 0
 	done := false
 
@@ -134,15 +262,11 @@ func (h Headers) Parse(data []byte) (int, bool, error) {
 			return 0, false, err
 		}
 
-		if !isValidToken([]byte(name)) {
+		if len(name) == 0 || !IsValidToken([]byte(name)) {
 			return 0, false, MALFORMED_FIELD_NAME
 		}
 
-		if h.Contains(name) {
-			h.Set(name, fmt.Sprintf("%s, %s", h.Get(name), value))
-		} else {
-			h.Set(name, value)
-		}
+		h.Add(name, value)
 
 		read += idx + len(CRLF)
 	}
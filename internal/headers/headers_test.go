@@ -48,4 +48,77 @@ func TestHeadersParse(t *testing.T) {
 	require.NotNil(t, headers)
 	assert.Equal(t, "lane-loves-go, prime-loves-zig, tj-loves-ocaml", headers.Get("Set-Person"))
 	assert.True(t, done)
+	// Test: bare LF, CR or NUL in a value can't hide another field
+	for _, line := range []string{"X: a\nContent-Length: 5\r\n\r\n", "X: a\rb\r\n\r\n", "X: a\x00b\r\n\r\n"} {
+		headers = NewHeaders()
+		_, _, err = headers.Parse([]byte(line))
+		assert.ErrorIs(t, err, MALFORMED_FIELD_LINE)
+		assert.False(t, headers.Contains("Content-Length"))
+	}
+}
+
+func TestHeadersMultiValue(t *testing.T) {
+	// Test: repeated fields keep every value
+	headers := NewHeaders()
+	data := []byte("Set-Cookie: a=1; Path=/\r\nX-Other: x\r\nSet-Cookie: b=2, c=3\r\n\r\n")
+
+	_, done, err := headers.Parse(data)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []string{"a=1; Path=/", "b=2, c=3"}, headers.Values("set-cookie"))
+	assert.Equal(t, []string{"set-cookie", "x-other"}, headers.Names())
+
+	// Test: Add, Set and Del
+	headers = NewHeaders()
+	headers.Add("Vary", "Accept")
+	headers.Add("vary", "Accept-Encoding")
+	assert.Equal(t, "Accept, Accept-Encoding", headers.Get("Vary"))
+	assert.True(t, headers.HasToken("Vary", "accept-encoding"))
+
+	headers.Set("Vary", "Origin")
+	assert.Equal(t, []string{"Origin"}, headers.Values("Vary"))
+
+	headers.Del("VARY")
+	assert.False(t, headers.Contains("Vary"))
+	assert.Empty(t, headers.Names())
+
+	// Test: empty values still count as present
+	headers.Set("X-Empty", "")
+	assert.True(t, headers.Contains("X-Empty"))
+
+	// Test: empty field name
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte(": value\r\n\r\n"))
+	require.Error(t, err)
+}
+
+func TestHeadersToString(t *testing.T) {
+	headers := NewHeaders()
+	headers.Set("content-type", "text/html")
+	headers.Add("set-cookie", "a=1")
+	headers.Set("X-REQUEST-ID", "42")
+	headers.Add("Set-Cookie", "b=2")
+	headers.Set("www-authenticate", "Basic")
+
+	assert.Equal(t,
+		"Content-Type: text/html\r\n"+
+			"Set-Cookie: a=1\r\n"+
+			"Set-Cookie: b=2\r\n"+
+			"X-Request-Id: 42\r\n"+
+			"Www-Authenticate: Basic\r\n"+
+			"\r\n",
+		headers.ToString())
+
+	// Test: values can't inject extra field lines
+	headers = NewHeaders()
+	headers.Set("X-Value", "a\r\nX-Injected: yes")
+	assert.Equal(t, "X-Value: a  X-Injected: yes\r\n\r\n", headers.ToString())
+
+	// Test: zero value is usable and Clone is independent
+	var zero Headers
+	zero.Set("A", "1")
+	clone := zero.Clone()
+	clone.Set("A", "2")
+	assert.Equal(t, "1", zero.Get("A"))
+	assert.Equal(t, "2", clone.Get("A"))
 }
@@ -0,0 +1,157 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+var ERROR_UNREAD_BODY = fmt.Errorf("Previous request body was not fully read")
+var ERROR_BODY_CLOSED = fmt.Errorf("Read on closed request body")
+
+// maxDrainSize is how much of an unread body Close will skip to keep the
+// connection usable for the next request.
+const maxDrainSize = 256 << 10
+
+// noBody is the Body of requests that have none.
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (noBody) Close() error {
+	return nil
+}
+
+// body reads a request body lazily from the connection's Reader.
+type body struct {
+	reader   *Reader
+	request  *Request
+	err      error
+	reusable bool
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newBody(reader *Reader, request *Request) *body {
+	return &body{
+		reader:  reader,
+		request: request,
+		done:    make(chan struct{}),
+	}
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if b.request.expectContinue && !b.request.continued {
+		b.request.continued = true
+
+		if b.request.sendContinue != nil {
+			if err := b.request.sendContinue(); err != nil {
+				b.finish(err)
+				return 0, err
+			}
+		}
+	}
+
+	for {
+		read, written, err := b.request.parseBody(b.reader.buf[:b.reader.bufIdx], p)
+
+		b.reader.consume(read)
+
+		if err != nil {
+			b.finish(err)
+			return written, err
+		}
+
+		if b.request.state == StateDone {
+			b.finish(io.EOF)
+
+			if written > 0 {
+				return written, nil
+			}
+
+			return 0, io.EOF
+		}
+
+		if written > 0 {
+			return written, nil
+		}
+
+		// chunk-size and trailer lines may need a bigger buffer
+		err = b.reader.growAndFill(b.reader.maxHeaderBytes())
+
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err == ERROR_HEADERS_TOO_LARGE && b.request.state != StateParsingTrailers {
+			err = ERROR_BAD_CHUNK
+		}
+
+		if err != nil {
+			b.finish(err)
+			return 0, err
+		}
+	}
+}
+
+// Close skips whatever is left of the body so the next request on the
+// connection can be read. Bodies too large to skip leave the connection
+// unusable.
+func (b *body) Close() error {
+	if b.err != nil {
+		if b.err == io.EOF {
+			b.err = ERROR_BODY_CLOSED
+		}
+
+		return nil
+	}
+
+	remaining := b.request.contentLength - b.request.bodyRead
+
+	// the client may never send a body it was not asked to continue with
+	if b.request.WaitingForContinue() {
+		b.finish(ERROR_UNREAD_BODY)
+		b.err = ERROR_BODY_CLOSED
+		return nil
+	}
+
+	if b.request.state == StateParsingBody && remaining > maxDrainSize {
+		b.finish(ERROR_UNREAD_BODY)
+		return nil
+	}
+
+	io.CopyN(io.Discard, b, maxDrainSize+1)
+
+	if b.err != io.EOF {
+		b.finish(ERROR_UNREAD_BODY)
+	}
+
+	b.err = ERROR_BODY_CLOSED
+
+	return nil
+}
+
+// finish records the final state of the body and wakes up WaitForBody.
+func (b *body) finish(err error) {
+	b.err = err
+
+	b.once.Do(func() {
+		b.reusable = err == io.EOF
+
+		if err != io.EOF {
+			b.request.bodyErr = err
+		}
+
+		close(b.done)
+	})
+}
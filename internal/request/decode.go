@@ -0,0 +1,106 @@
+package request
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ERROR_UNSUPPORTED_CONTENT_ENCODING = fmt.Errorf("Unsupported Content-Encoding")
+
+// SUPPORTED_CONTENT_ENCODINGS are the codings DecodeBody can undo.
+var SUPPORTED_CONTENT_ENCODINGS = []string{"gzip", "deflate"}
+
+// DecodeBody replaces Body with one undoing the Content-Encoding of the
+// request, and drops the Content-Encoding and Content-Length headers that
+// no longer apply. Reads past maxSize decoded bytes fail with
+// ERROR_BODY_TOO_LARGE, a maxSize of zero means no limit. Codings other
+// than gzip and deflate return ERROR_UNSUPPORTED_CONTENT_ENCODING and leave
+// the request untouched.
+func (r *Request) DecodeBody(maxSize int) error {
+	var encodings []string
+
+	for _, value := range r.Headers.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+
+			switch coding {
+			case "", "identity":
+			case "gzip", "x-gzip", "deflate":
+				encodings = append(encodings, coding)
+			default:
+				return ERROR_UNSUPPORTED_CONTENT_ENCODING
+			}
+		}
+	}
+
+	r.Headers.Del("Content-Encoding")
+
+	if len(encodings) == 0 {
+		return nil
+	}
+
+	r.Headers.Del("Content-Length")
+	r.Body = &decodedBody{source: r.Body, encodings: encodings, maxSize: maxSize}
+
+	return nil
+}
+
+// decodedBody decodes lazily, so the decoders only touch the connection on
+// the handler's first read.
+type decodedBody struct {
+	source    io.ReadCloser
+	encodings []string
+	reader    io.Reader
+	maxSize   int
+	read      int
+	err       error
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	if d.reader == nil {
+		reader := io.Reader(d.source)
+
+		// codings are listed in the order they were applied
+		for i := len(d.encodings) - 1; i >= 0; i-- {
+			var err error
+
+			if d.encodings[i] == "deflate" {
+				reader, err = zlib.NewReader(reader)
+			} else {
+				reader, err = gzip.NewReader(reader)
+			}
+
+			if err != nil {
+				d.err = err
+				return 0, err
+			}
+		}
+
+		d.reader = reader
+	}
+
+	n, err := d.reader.Read(p)
+	d.read += n
+
+	if d.maxSize > 0 && d.read > d.maxSize {
+		d.err = ERROR_BODY_TOO_LARGE
+		return n - (d.read - d.maxSize), d.err
+	}
+
+	if err != nil {
+		d.err = err
+	}
+
+	return n, err
+}
+
+func (d *decodedBody) Close() error {
+	return d.source.Close()
+}
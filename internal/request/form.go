@@ -0,0 +1,205 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+)
+
+const (
+	// DEFAULT_MAX_FORM_SIZE limits the body read by ParseForm.
+	DEFAULT_MAX_FORM_SIZE = 10 << 20
+
+	// DEFAULT_MAX_FORM_MEMORY is how much of a multipart form ParseForm
+	// keeps in memory, larger files are written to temporary files.
+	DEFAULT_MAX_FORM_MEMORY = 32 << 20
+)
+
+var ERROR_NOT_MULTIPART = fmt.Errorf("Request Content-Type is not multipart/form-data")
+var ERROR_FORM_TOO_LARGE = fmt.Errorf("Request form too large")
+var ERROR_FORM_ALREADY_READ = fmt.Errorf("Request form body already read")
+var ERROR_MISSING_FILE = fmt.Errorf("No file uploaded in form field")
+
+// ParseForm fills Form with the query parameters and the fields of a
+// application/x-www-form-urlencoded or multipart/form-data body, body
+// values first. Multipart forms are parsed with DEFAULT_MAX_FORM_MEMORY
+// and DEFAULT_MAX_FORM_SIZE, call ParseMultipartForm first for other
+// limits. Calling it again does nothing.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if r.formRead {
+			return ERROR_FORM_ALREADY_READ
+		}
+
+		r.formRead = true
+		data, err := io.ReadAll(&limitedReader{reader: r.Body, remaining: DEFAULT_MAX_FORM_SIZE, err: ERROR_FORM_TOO_LARGE})
+
+		if err != nil {
+			return err
+		}
+
+		values, err := url.ParseQuery(string(data))
+
+		if err != nil {
+			return err
+		}
+
+		r.Form = values
+
+	case "multipart/form-data":
+		return r.ParseMultipartForm(DEFAULT_MAX_FORM_MEMORY, DEFAULT_MAX_FORM_SIZE)
+
+	default:
+		r.Form = url.Values{}
+	}
+
+	r.addQueryToForm()
+
+	return nil
+}
+
+// ParseMultipartForm reads a multipart/form-data body into MultipartForm
+// and its fields into Form. Up to maxMemory bytes of files are kept in
+// memory and the rest is written to temporary files, which RemoveFormFiles
+// deletes. Bodies over maxSize fail with ERROR_FORM_TOO_LARGE, zero means
+// DEFAULT_MAX_FORM_SIZE.
+func (r *Request) ParseMultipartForm(maxMemory, maxSize int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+
+	reader, err := r.multipartReader(maxSize)
+
+	if err != nil {
+		return err
+	}
+
+	form, err := reader.ReadForm(maxMemory)
+
+	if err != nil {
+		if errors.Is(err, ERROR_FORM_TOO_LARGE) || errors.Is(err, multipart.ErrMessageTooLarge) {
+			return ERROR_FORM_TOO_LARGE
+		}
+
+		return err
+	}
+
+	r.MultipartForm = form
+	r.Form = url.Values{}
+
+	for name, values := range form.Value {
+		r.Form[name] = append(r.Form[name], values...)
+	}
+
+	r.addQueryToForm()
+
+	return nil
+}
+
+// MultipartReader returns a reader streaming the parts of a
+// multipart/form-data body one by one, with their own headers, instead of
+// parsing the whole form. Reading past maxSize bytes of body fails with
+// ERROR_FORM_TOO_LARGE, zero means DEFAULT_MAX_FORM_SIZE.
+func (r *Request) MultipartReader(maxSize int64) (*multipart.Reader, error) {
+	return r.multipartReader(maxSize)
+}
+
+func (r *Request) multipartReader(maxSize int64) (*multipart.Reader, error) {
+	if r.formRead {
+		return nil, ERROR_FORM_ALREADY_READ
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, ERROR_NOT_MULTIPART
+	}
+
+	r.formRead = true
+
+	if maxSize <= 0 {
+		maxSize = DEFAULT_MAX_FORM_SIZE
+	}
+
+	return multipart.NewReader(&limitedReader{reader: r.Body, remaining: maxSize, err: ERROR_FORM_TOO_LARGE}, params["boundary"]), nil
+}
+
+// FormValue returns the first value of the named form field or query
+// parameter, parsing the form if needed. Parse errors are ignored, call
+// ParseForm to see them.
+func (r *Request) FormValue(name string) string {
+	r.ParseForm()
+
+	return r.Form.Get(name)
+}
+
+// FormFile returns the first file uploaded in the named multipart field.
+func (r *Request) FormFile(name string) (multipart.File, *multipart.FileHeader, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, err
+	}
+
+	if r.MultipartForm == nil || len(r.MultipartForm.File[name]) == 0 {
+		return nil, nil, ERROR_MISSING_FILE
+	}
+
+	header := r.MultipartForm.File[name][0]
+	file, err := header.Open()
+
+	return file, header, err
+}
+
+// RemoveFormFiles deletes the temporary files of MultipartForm.
+func (r *Request) RemoveFormFiles() error {
+	if r.MultipartForm == nil {
+		return nil
+	}
+
+	return r.MultipartForm.RemoveAll()
+}
+
+func (r *Request) addQueryToForm() {
+	for name, values := range r.URL.Query {
+		r.Form[name] = append(r.Form[name], values...)
+	}
+}
+
+// limitedReader fails with err instead of stopping quietly like
+// io.LimitReader.
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+	err       error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// a body ending right at the limit is fine
+		n, err := l.reader.Read(p[:min(len(p), 1)])
+
+		if n > 0 {
+			return 0, l.err
+		}
+
+		return 0, err
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+
+	return n, err
+}
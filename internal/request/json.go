@@ -0,0 +1,64 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// DEFAULT_MAX_JSON_SIZE limits the body DecodeJSON reads.
+const DEFAULT_MAX_JSON_SIZE = 1 << 20
+
+var ERROR_NOT_JSON = fmt.Errorf("Request Content-Type is not JSON")
+var ERROR_INVALID_JSON = fmt.Errorf("Invalid JSON request body")
+
+// DecodeJSON decodes the JSON body of req into v. The Content-Type must be
+// application/json or another +json type, otherwise it returns
+// ERROR_NOT_JSON. Bodies over DEFAULT_MAX_JSON_SIZE fail with
+// ERROR_BODY_TOO_LARGE, and malformed JSON, fields v doesn't have and
+// trailing data with an error wrapping ERROR_INVALID_JSON.
+func DecodeJSON(req *Request, v any) error {
+	return DecodeJSONWithLimit(req, v, DEFAULT_MAX_JSON_SIZE)
+}
+
+// DecodeJSONWithLimit is DecodeJSON with a body limit of maxSize bytes.
+func DecodeJSONWithLimit(req *Request, v any, maxSize int64) error {
+	mediaType, _, err := mime.ParseMediaType(req.Headers.Get("Content-Type"))
+
+	if err != nil || mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return ERROR_NOT_JSON
+	}
+
+	body := &limitedReader{reader: req.Body, remaining: maxSize, err: ERROR_BODY_TOO_LARGE}
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return jsonError(err)
+	}
+
+	// only whitespace may follow the value
+	if _, err := decoder.Token(); err != io.EOF {
+		if err == nil {
+			err = fmt.Errorf("unexpected data after the JSON value")
+		}
+
+		return jsonError(err)
+	}
+
+	return nil
+}
+
+func jsonError(err error) error {
+	if err == ERROR_BODY_TOO_LARGE {
+		return err
+	}
+
+	if err == io.EOF {
+		err = fmt.Errorf("empty body")
+	}
+
+	return fmt.Errorf("%w: %v", ERROR_INVALID_JSON, err)
+}
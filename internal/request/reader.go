@@ -0,0 +1,193 @@
+package request
+
+import (
+	"bytes"
+	"io"
+)
+
+const (
+	DEFAULT_MAX_REQUEST_LINE_LENGTH = 8 << 10
+	DEFAULT_MAX_HEADER_BYTES        = 64 << 10
+	DEFAULT_MAX_HEADER_COUNT        = 100
+)
+
+// Reader parses consecutive requests from a single connection. Bytes read
+// past the end of one request are kept for the next one, so pipelined
+// requests are not lost.
+type Reader struct {
+	// MaxBodySize limits the size of a single request body. Zero means
+	// no limit.
+	MaxBodySize int
+
+	// MaxRequestLineLength limits the request line, longer lines fail with
+	// ERROR_REQUEST_LINE_TOO_LONG. Zero means DEFAULT_MAX_REQUEST_LINE_LENGTH.
+	MaxRequestLineLength int
+
+	// MaxHeaderBytes limits the request line and header fields together,
+	// MaxHeaderCount the number of header field lines. Both fail with
+	// ERROR_HEADERS_TOO_LARGE. Zero means the matching DEFAULT_ constant.
+	MaxHeaderBytes int
+	MaxHeaderCount int
+
+	reader  io.Reader
+	buf     []byte
+	bufIdx  int
+	current *body
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, 1024),
+	}
+}
+
+// Buffered returns the number of bytes already read from the connection
+// that belong to requests not parsed yet.
+func (r *Reader) Buffered() int {
+	return r.bufIdx
+}
+
+// WaitForBody blocks until the body of the last request has been read to
+// the end or closed. It returns ERROR_UNREAD_BODY if the rest of the body
+// could not be skipped and the connection can't carry another request.
+func (r *Reader) WaitForBody() error {
+	if r.current == nil {
+		return nil
+	}
+
+	<-r.current.done
+
+	if !r.current.reusable {
+		return ERROR_UNREAD_BODY
+	}
+
+	r.current = nil
+
+	return nil
+}
+
+// WaitForData blocks until at least one byte of the next request is
+// buffered, so callers can tell an idle connection from a slow request.
+func (r *Reader) WaitForData() error {
+	for r.bufIdx == 0 {
+		if err := r.fill(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Reader) ReadRequest() (*Request, error) {
+	if err := r.WaitForBody(); err != nil {
+		return nil, err
+	}
+
+	request := newRequest()
+	request.maxBodySize = r.MaxBodySize
+	request.maxHeaderCount = orDefault(r.MaxHeaderCount, DEFAULT_MAX_HEADER_COUNT)
+	request.maxHeaderBytes = r.maxHeaderBytes()
+
+	maxLine := orDefault(r.MaxRequestLineLength, DEFAULT_MAX_REQUEST_LINE_LENGTH)
+	maxHeaderBytes := r.maxHeaderBytes()
+	consumed := 0
+
+	for {
+		if request.state == StateInitialized {
+			idx := bytes.Index(r.buf[:r.bufIdx], SEPARATOR)
+
+			if idx > maxLine || idx == -1 && r.bufIdx > maxLine {
+				return nil, ERROR_REQUEST_LINE_TOO_LONG
+			}
+		}
+
+		readN, err := request.parse(r.buf[:r.bufIdx])
+
+		if err != nil {
+			return nil, err
+		}
+
+		r.consume(readN)
+		consumed += readN
+
+		if consumed > maxHeaderBytes {
+			return nil, ERROR_HEADERS_TOO_LARGE
+		}
+
+		if request.headersDone() {
+			break
+		}
+
+		if consumed+r.bufIdx > maxHeaderBytes {
+			return nil, ERROR_HEADERS_TOO_LARGE
+		}
+
+		err = r.growAndFill(maxHeaderBytes)
+
+		if err == io.EOF && (request.state != StateInitialized || r.bufIdx > 0) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !request.done() {
+		r.current = newBody(r, request)
+		request.Body = r.current
+	}
+
+	return request, nil
+}
+
+// consume drops n parsed bytes from the front of the buffer.
+func (r *Reader) consume(n int) {
+	copy(r.buf, r.buf[n:r.bufIdx])
+	r.bufIdx -= n
+}
+
+// growAndFill is fill for a buffer that may have to grow up to limit bytes
+// to hold a single line.
+func (r *Reader) growAndFill(limit int) error {
+	if r.bufIdx == len(r.buf) && len(r.buf) < limit {
+		buf := make([]byte, min(2*len(r.buf), limit))
+		copy(buf, r.buf[:r.bufIdx])
+		r.buf = buf
+	}
+
+	return r.fill()
+}
+
+// fill reads more data from the connection into the buffer.
+func (r *Reader) fill() error {
+	if r.bufIdx == len(r.buf) {
+		return ERROR_HEADERS_TOO_LARGE
+	}
+
+	n, err := r.reader.Read(r.buf[r.bufIdx:])
+	r.bufIdx += n
+
+	if n > 0 {
+		return nil
+	}
+
+	return err
+}
+
+func (r *Reader) maxHeaderBytes() int {
+	return orDefault(r.MaxHeaderBytes, DEFAULT_MAX_HEADER_BYTES)
+}
+
+func orDefault(value, fallback int) int {
+	if value > 0 {
+		return value
+	}
+
+	return fallback
+}
+
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadRequest()
+}
@@ -2,9 +2,13 @@ package request
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"go-http/internal/cookie"
 	"go-http/internal/headers"
 	"io"
+	"mime/multipart"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -17,6 +21,11 @@ const (
 	StateParsingBody    parserState = 2
 	StateDone           parserState = 3
 	StateError          parserState = 4
+
+	StateParsingChunkSize parserState = 5
+	StateParsingChunkData parserState = 6
+	StateParsingChunkEnd  parserState = 7
+	StateParsingTrailers  parserState = 8
 )
 
 type RequestLine struct {
@@ -26,18 +35,50 @@ type RequestLine struct {
 }
 
 type Request struct {
-	RequestLine   RequestLine
-	Headers       headers.Headers
-	Body          []byte
-	state         parserState
-	contentLength int
+	RequestLine RequestLine
+	// URL is RequestLine.RequestTarget parsed, with the path decoded and
+	// normalized
+	URL URL
+	// Host is the lowercased host and optional port the request is for,
+	// taken from an absolute-form target or else the Host header
+	Host    string
+	Headers headers.Headers
+	// Body streams the request body from the connection. It is never nil
+	// and returns io.EOF right away for requests without a body.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields sent after a chunked body, they
+	// are only available once Body has been read to the end
+	Trailers headers.Headers
+	// Form holds the query parameters and form fields once ParseForm or
+	// ParseMultipartForm ran, MultipartForm the parsed multipart body
+	Form           url.Values
+	MultipartForm  *multipart.Form
+	formRead       bool
+	ctx            context.Context
+	serverDone     <-chan struct{}
+	pathValues     map[string]string
+	expectContinue bool
+	continued      bool
+	bodyErr        error
+	sendContinue   func() error
+	state          parserState
+	contentLength  int
+	bodyRead       int
+	maxBodySize    int
+	headerCount    int
+	maxHeaderCount int
+	maxHeaderBytes int
+	trailerCount   int
+	trailerBytes   int
+	chunkRemaining int
 }
 
 func newRequest() *Request {
 	return &Request{
-		Headers: *headers.NewHeaders(),
-		state:   StateInitialized,
-		Body:    make([]byte, 0),
+		Headers:  *headers.NewHeaders(),
+		Trailers: *headers.NewHeaders(),
+		state:    StateInitialized,
+		Body:     noBody{},
 	}
 }
 
@@ -45,6 +86,114 @@ func (r *Request) done() bool {
 	return r.state == StateDone || r.state == StateError
 }
 
+// headersDone reports whether the request line and headers are parsed and
+// only the body is left.
+func (r *Request) headersDone() bool {
+	return r.state != StateInitialized && r.state != StateParsingHeaders
+}
+
+// PathValue returns the value a router matched for the named wildcard in
+// the route pattern, or an empty string.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = map[string]string{}
+	}
+
+	r.pathValues[name] = value
+}
+
+// ExpectsContinue reports whether the client sent "Expect: 100-continue"
+// and waits for an interim response before sending the body. Handlers
+// reject such a request early by answering without reading the body.
+func (r *Request) ExpectsContinue() bool {
+	return r.expectContinue
+}
+
+// BodyErr returns the error reading the body stopped with, like
+// ERROR_BODY_TOO_LARGE. It is nil while the body is read and once it was
+// read to the end.
+func (r *Request) BodyErr() error {
+	return r.bodyErr
+}
+
+// WaitingForContinue reports whether the client still holds its body back
+// waiting for "100 Continue". A response sent now leaves the body unread,
+// so the connection can't be reused after it.
+func (r *Request) WaitingForContinue() bool {
+	return r.expectContinue && !r.continued && r.state != StateDone
+}
+
+// SetContinueFunc sets the function sending the "100 Continue" interim
+// response. It runs once, on the first read of a body the client holds
+// back.
+func (r *Request) SetContinueFunc(fn func() error) {
+	r.sendContinue = fn
+}
+
+// Context is done once the client disconnects or the server is closed.
+// It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+
+	return r.ctx
+}
+
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// ServerDone is closed once the server starts shutting down, so that
+// long-lived responses like event streams can end. It is nil, and never
+// closes, for a request not served by a Server.
+func (r *Request) ServerDone() <-chan struct{} {
+	return r.serverDone
+}
+
+func (r *Request) SetServerDone(done <-chan struct{}) {
+	r.serverDone = done
+}
+
+// LastEventID returns the Last-Event-ID header an EventSource sends when
+// it reconnects, the ID of the last server-sent event it received.
+func (r *Request) LastEventID() string {
+	return r.Headers.Get("Last-Event-ID")
+}
+
+var ERROR_NO_COOKIE = fmt.Errorf("Named cookie not present")
+
+// Cookies parses the Cookie headers of the request.
+func (r *Request) Cookies() []*cookie.Cookie {
+	var cookies []*cookie.Cookie
+
+	for _, value := range r.Headers.Values("Cookie") {
+		cookies = append(cookies, cookie.Parse(value)...)
+	}
+
+	return cookies
+}
+
+// Cookie returns the first cookie called name, or ERROR_NO_COOKIE.
+func (r *Request) Cookie(name string) (*cookie.Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+
+	return nil, ERROR_NO_COOKIE
+}
+
+// ReadAll reads the whole body into memory.
+func (r *Request) ReadAll() ([]byte, error) {
+	return io.ReadAll(r.Body)
+}
+
 func (r *Request) parse(data []byte) (int, error) {
 	read := 0
 
@@ -61,7 +210,14 @@ outer:
 			}
 
 			if rLine != nil {
+				url, err := parseTarget(rLine.Method, rLine.RequestTarget)
+
+				if err != nil {
+					return 0, err
+				}
+
 				r.RequestLine = *rLine
+				r.URL = url
 				read += readN
 				r.state = StateParsingHeaders
 			}
@@ -83,61 +239,337 @@ outer:
 
 			read += readN
 
+			r.headerCount += bytes.Count(currentData[:readN], SEPARATOR)
+
 			if done {
-				if !r.Headers.Contains("Content-Length") {
-					r.state = StateDone
-					continue
-				}
+				r.headerCount-- // the empty line ending the headers
+			}
 
-				contentLenStr := r.Headers.Get("Content-Length")
-				contentLength, err := strconv.Atoi(contentLenStr)
+			if r.maxHeaderCount > 0 && r.headerCount > r.maxHeaderCount {
+				r.state = StateError
+				return 0, ERROR_HEADERS_TOO_LARGE
+			}
 
-				if contentLength == 0 {
-					r.state = StateDone
-					break
+			if done {
+				if err := r.setHost(); err != nil {
+					r.state = StateError
+					return 0, err
 				}
 
+				if err := r.setExpect(); err != nil {
+					r.state = StateError
+					return 0, err
+				}
+
+				state, err := r.bodyState()
+
 				if err != nil {
 					r.state = StateError
-					return 0, fmt.Errorf("Malformed Content-Length header: %s", contentLenStr)
+					return 0, err
 				}
 
-				r.contentLength = contentLength
-				r.state = StateParsingBody
+				r.state = state
 			}
 
+		default:
+			break outer
+		}
+	}
+
+	return read, nil
+}
+
+// parseBody decodes body framing from data and copies the body bytes into
+// out. It returns how much of data was consumed and how much of out was
+// filled.
+func (r *Request) parseBody(data []byte, out []byte) (int, int, error) {
+	read := 0
+	written := 0
+
+outer:
+	for {
+		currentData := data[read:]
+
+		switch r.state {
 		case StateParsingBody:
-			if len(currentData) == 0 {
-				if r.contentLength != len(r.Body) {
-					r.state = StateError
-					return 0, fmt.Errorf("Content-Length doesn't match actual body length")
-				}
+			// never consume past Content-Length, the rest belongs to the
+			// next request on a persistent connection
+			n := min(len(currentData), r.contentLength-r.bodyRead, len(out)-written)
+
+			if n == 0 {
+				break outer
+			}
 
+			copy(out[written:], currentData[:n])
+			read += n
+			written += n
+			r.bodyRead += n
+
+			if r.bodyRead == r.contentLength {
 				r.state = StateDone
+			}
+
+		case StateParsingChunkSize:
+			idx := bytes.Index(currentData, SEPARATOR)
+
+			if idx == -1 {
+				break outer
+			}
+
+			size, err := parseChunkSize(currentData[:idx])
+
+			if err != nil {
+				r.state = StateError
+				return read, written, err
+			}
+
+			if r.maxBodySize > 0 && r.bodyRead+size > r.maxBodySize {
+				r.state = StateError
+				return read, written, ERROR_BODY_TOO_LARGE
+			}
+
+			read += idx + len(SEPARATOR)
+
+			if size == 0 {
+				r.state = StateParsingTrailers
 				continue
 			}
 
-			r.Body = append(r.Body, currentData[len(r.Body):]...)
+			r.chunkRemaining = size
+			r.state = StateParsingChunkData
+
+		case StateParsingChunkData:
+			n := min(len(currentData), r.chunkRemaining, len(out)-written)
+
+			if n == 0 {
+				break outer
+			}
+
+			copy(out[written:], currentData[:n])
+			read += n
+			written += n
+			r.bodyRead += n
+			r.chunkRemaining -= n
+
+			if r.chunkRemaining == 0 {
+				r.state = StateParsingChunkEnd
+			}
+
+		case StateParsingChunkEnd:
+			if len(currentData) < len(SEPARATOR) {
+				break outer
+			}
 
-			if r.contentLength == len(r.Body) {
+			if !bytes.HasPrefix(currentData, SEPARATOR) {
+				r.state = StateError
+				return read, written, ERROR_BAD_CHUNK
+			}
+
+			read += len(SEPARATOR)
+			r.state = StateParsingChunkSize
+
+		case StateParsingTrailers:
+			readN, done, err := r.Trailers.Parse(currentData)
+
+			if err != nil {
+				r.state = StateError
+				return read, written, err
+			}
+
+			read += readN
+
+			// trailers get the same budget as the header section
+			r.trailerBytes += readN
+			r.trailerCount += bytes.Count(currentData[:readN], SEPARATOR)
+
+			if done {
+				r.trailerCount-- // the empty line ending the trailers
+			}
+
+			if r.maxHeaderCount > 0 && r.trailerCount > r.maxHeaderCount ||
+				r.maxHeaderBytes > 0 && r.trailerBytes > r.maxHeaderBytes {
+				r.state = StateError
+				return read, written, ERROR_HEADERS_TOO_LARGE
+			}
+
+			if done {
 				r.state = StateDone
 				continue
 			}
 
 			break outer
 
-		case StateDone:
+		default:
 			break outer
 		}
 	}
 
-	return read, nil
+	return read, written, nil
+}
+
+var ERROR_BODY_TOO_LARGE = fmt.Errorf("Request body too large")
+var ERROR_REQUEST_LINE_TOO_LONG = fmt.Errorf("Request line too long")
+var ERROR_HEADERS_TOO_LARGE = fmt.Errorf("Request header fields too large")
+var ERROR_BAD_CHUNK = fmt.Errorf("Malformed chunk")
+var ERROR_LENGTH_AND_TRANSFER_ENCODING = fmt.Errorf("Both Content-Length and Transfer-Encoding are set")
+var ERROR_TRANSFER_ENCODING_NOT_SUPPORTED = fmt.Errorf("Transfer-Encoding not supported")
+
+var ERROR_BAD_HOST = fmt.Errorf("Missing, duplicate or invalid Host header")
+
+// setHost checks the Host header, HTTP/1.1 requests need exactly one
+// (RFC 9112 3.2). The authority of an absolute-form target takes precedence
+// over it.
+func (r *Request) setHost() error {
+	values := r.Headers.Values("Host")
+
+	if len(values) > 1 || len(values) == 0 && r.RequestLine.HttpVersion != "1.0" {
+		return ERROR_BAD_HOST
+	}
+
+	host := ""
+
+	if len(values) == 1 {
+		host = values[0]
+
+		if host != "" && !isHost(host) && !isAuthority(host) || strings.Contains(host, ",") {
+			return ERROR_BAD_HOST
+		}
+	}
+
+	if r.URL.Form == TargetAbsoluteForm || r.URL.Form == TargetAuthorityForm {
+		host = r.URL.Authority
+	}
+
+	r.Host = strings.ToLower(host)
+
+	return nil
+}
+
+var ERROR_EXPECTATION_FAILED = fmt.Errorf("Unsupported expectation")
+
+// setExpect checks the Expect header, 100-continue is the only expectation
+// defined (RFC 9110 10.1.1). HTTP/1.0 clients don't know about it, so their
+// expectations are ignored.
+func (r *Request) setExpect() error {
+	if !r.Headers.Contains("Expect") || r.RequestLine.HttpVersion == "1.0" {
+		return nil
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(r.Headers.Get("Expect")), "100-continue") {
+		return ERROR_EXPECTATION_FAILED
+	}
+
+	r.expectContinue = true
+
+	return nil
+}
+
+// bodyState decides how the body is framed once all headers are parsed.
+// Transfer-Encoding together with Content-Length is rejected outright since
+// the two can be used to smuggle requests past a proxy (RFC 9112 6.1).
+func (r *Request) bodyState() (parserState, error) {
+	if r.Headers.Contains("Transfer-Encoding") {
+		// HTTP/1.0 has no chunked encoding, the framing can't be trusted
+		if r.RequestLine.HttpVersion == "1.0" {
+			return StateError, fmt.Errorf("Transfer-Encoding in an HTTP/1.0 request")
+		}
+
+		if r.Headers.Contains("Content-Length") {
+			return StateError, ERROR_LENGTH_AND_TRANSFER_ENCODING
+		}
+
+		if !strings.EqualFold(strings.TrimSpace(r.Headers.Get("Transfer-Encoding")), "chunked") {
+			return StateError, ERROR_TRANSFER_ENCODING_NOT_SUPPORTED
+		}
+
+		return StateParsingChunkSize, nil
+	}
+
+	if !r.Headers.Contains("Content-Length") {
+		return StateDone, nil
+	}
+
+	// 1*DIGIT only, Atoi would also take a sign
+	contentLenStr := r.Headers.Get("Content-Length")
+
+	if contentLenStr == "" || strings.TrimLeft(contentLenStr, "0123456789") != "" {
+		return StateError, fmt.Errorf("Malformed Content-Length header: %s", contentLenStr)
+	}
+
+	contentLength, err := strconv.Atoi(contentLenStr)
+
+	if err != nil {
+		return StateError, fmt.Errorf("Malformed Content-Length header: %s", contentLenStr)
+	}
+
+	if contentLength == 0 {
+		return StateDone, nil
+	}
+
+	if r.maxBodySize > 0 && contentLength > r.maxBodySize {
+		return StateError, ERROR_BODY_TOO_LARGE
+	}
+
+	r.contentLength = contentLength
+
+	return StateParsingBody, nil
+}
+
+// parseChunkSize parses a chunk-size line. Chunk extensions are checked
+// for a valid name and otherwise ignored.
+func parseChunkSize(line []byte) (int, error) {
+	sizePart, extensions, _ := bytes.Cut(line, []byte(";"))
+	sizePart = bytes.TrimRight(sizePart, " \t")
+
+	if len(sizePart) == 0 || len(sizePart) > 15 {
+		return 0, ERROR_BAD_CHUNK
+	}
+
+	for _, ch := range sizePart {
+		if !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F') {
+			return 0, ERROR_BAD_CHUNK
+		}
+	}
+
+	if len(extensions) > 0 {
+		for _, ext := range bytes.Split(extensions, []byte(";")) {
+			name, _, _ := bytes.Cut(ext, []byte("="))
+			name = bytes.Trim(name, " \t")
+
+			if len(name) == 0 || !headers.IsValidToken(name) {
+				return 0, ERROR_BAD_CHUNK
+			}
+		}
+	}
+
+	size, err := strconv.ParseInt(string(sizePart), 16, 64)
+
+	if err != nil {
+		return 0, ERROR_BAD_CHUNK
+	}
+
+	return int(size), nil
 }
 
 var ERROR_BAD_START_LINE = fmt.Errorf("Invalid start line")
 var ERROR_HTTP_VERSION_NOT_SUPPORTED = fmt.Errorf("HTTP version not supported")
+var ERROR_METHOD_NOT_IMPLEMENTED = fmt.Errorf("Method not implemented")
 var SEPARATOR = []byte("\r\n")
 
+// knownMethods are the methods of RFC 9110 9.3 and PATCH. Methods are case
+// sensitive, so "get" is a valid token but not a known method.
+var knownMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"CONNECT": true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PATCH":   true,
+}
+
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
 	idx := bytes.Index(data, SEPARATOR)
 
@@ -150,59 +582,47 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 
 	rawLineArr := bytes.Split(rawLine, []byte(" "))
 
-	if len(rawLineArr) != 3 {
+	if len(rawLineArr) != 3 || len(rawLineArr[0]) == 0 || len(rawLineArr[1]) == 0 {
 		return nil, read, ERROR_BAD_START_LINE
 	}
 
-	method := string(rawLineArr[0])
-
-	if strings.ToUpper(method) != method {
+	if !headers.IsValidToken(rawLineArr[0]) {
 		return nil, read, ERROR_BAD_START_LINE
 	}
 
+	method := string(rawLineArr[0])
 	path := string(rawLineArr[1])
 
-	httpVersion := string(rawLineArr[2])
+	versionNumber, err := parseHttpVersion(string(rawLineArr[2]))
 
-	if httpVersion != "HTTP/1.1" {
-		return nil, read, ERROR_HTTP_VERSION_NOT_SUPPORTED
+	if err != nil {
+		return nil, read, err
 	}
 
-	versionNumber := strings.Split(httpVersion, "/")[1]
+	if !knownMethods[method] {
+		return nil, read, ERROR_METHOD_NOT_IMPLEMENTED
+	}
 
 	return &RequestLine{Method: method, RequestTarget: path, HttpVersion: versionNumber}, read, nil
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	request := newRequest()
-
-	buf := make([]byte, 1024)
-	bufIdx := 0
+// parseHttpVersion returns the version number of an HTTP-version
+// ("HTTP/" DIGIT "." DIGIT), only 1.0 and 1.1 are supported.
+func parseHttpVersion(httpVersion string) (string, error) {
+	versionNumber, found := strings.CutPrefix(httpVersion, "HTTP/")
 
-	for !request.done() {
-		n, err := reader.Read(buf[bufIdx:])
-
-		if err != nil {
-			return nil, err
-		}
-
-		bufIdx += n
-
-		currentBuffer := make([]byte, 0)
-
-		if err != io.EOF {
-			currentBuffer = buf[:bufIdx]
-		}
-
-		readN, err := request.parse(currentBuffer)
-
-		if err != nil {
-			return nil, err
-		}
+	if !found || len(versionNumber) != 3 || versionNumber[1] != '.' ||
+		!isDigit(versionNumber[0]) || !isDigit(versionNumber[2]) {
+		return "", ERROR_BAD_START_LINE
+	}
 
-		copy(buf, buf[readN:bufIdx])
-		bufIdx -= readN
+	if versionNumber != "1.0" && versionNumber != "1.1" {
+		return "", ERROR_HTTP_VERSION_NOT_SUPPORTED
 	}
 
-	return request, nil
+	return versionNumber, nil
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
 }
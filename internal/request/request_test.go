@@ -1,7 +1,13 @@
 package request
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
 	"io"
+	"mime/multipart"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,6 +39,13 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+func readBody(t *testing.T, r *Request) string {
+	body, err := r.ReadAll()
+	require.NoError(t, err)
+
+	return string(body)
+}
+
 func TestRequestLineParse(t *testing.T) {
 	// Test: Good GET Request line
 	// Test: Good GET Request line
@@ -60,6 +73,44 @@ func TestRequestLineParse(t *testing.T) {
 	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
 }
 
+func TestRequestLineValidation(t *testing.T) {
+	parse := func(line string) (*Request, error) {
+		return RequestFromReader(strings.NewReader(line + "\r\nHost: localhost\r\n\r\n"))
+	}
+
+	// Test: HTTP/1.0 request line
+	r, err := parse("GET / HTTP/1.0")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+
+	// Test: methods must be tokens
+	for _, line := range []string{"GET1@ / HTTP/1.1", "G(ET / HTTP/1.1", " / HTTP/1.1", "GET  / HTTP/1.1", "GET / HTTP/1.1 "} {
+		_, err = parse(line)
+		assert.ErrorIs(t, err, ERROR_BAD_START_LINE, line)
+	}
+
+	// Test: valid tokens that aren't known methods
+	for _, line := range []string{"get / HTTP/1.1", "BREW / HTTP/1.1"} {
+		_, err = parse(line)
+		assert.ErrorIs(t, err, ERROR_METHOD_NOT_IMPLEMENTED, line)
+	}
+
+	// Test: unsupported and malformed versions
+	for _, line := range []string{"GET / HTTP/2.0", "GET / HTTP/0.9", "GET / HTTP/1.2"} {
+		_, err = parse(line)
+		assert.ErrorIs(t, err, ERROR_HTTP_VERSION_NOT_SUPPORTED, line)
+	}
+
+	for _, line := range []string{"GET / HTTP/1", "GET / http/1.1", "GET / HTTP/1.1.1"} {
+		_, err = parse(line)
+		assert.ErrorIs(t, err, ERROR_BAD_START_LINE, line)
+	}
+
+	// Test: no chunked bodies in HTTP/1.0
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	require.Error(t, err)
+}
+
 func TestHeaderParse(t *testing.T) {
 	// Test: Standard Headers
 	reader := &chunkReader{
@@ -96,7 +147,7 @@ func TestBodyParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "hello world!\n", readBody(t, r))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -108,7 +159,9 @@ func TestBodyParse(t *testing.T) {
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
-	require.Error(t, err)
+	require.NoError(t, err)
+	_, err = r.ReadAll()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
 
 	// Test: no content length and body
 	reader = &chunkReader{
@@ -124,3 +177,651 @@ func TestBodyParse(t *testing.T) {
 	require.NotNil(t, r)
 
 }
+
+func TestReaderPipelined(t *testing.T) {
+	// Test: several requests arriving in a single read
+	reader := NewReader(&chunkReader{
+		data: "GET /first HTTP/1.1\r\nHost: localhost:42069\r\n\r\n" +
+			"POST /second HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /third HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 1024,
+	})
+
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Greater(t, reader.Buffered(), 0)
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", readBody(t, r))
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+	assert.Equal(t, 0, reader.Buffered())
+
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: connection closed in the middle of a request
+	reader = NewReader(&chunkReader{
+		data:            "GET /first HTTP/1.1\r\nHost: local",
+		numBytesPerRead: 3,
+	})
+
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: chunked body with extensions and trailers
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6;name=value\r\nhello \r\n" +
+			"6\r\nworld!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", readBody(t, r))
+	assert.Equal(t, "abc", r.Trailers.Get("X-Checksum"))
+
+	// Test: pipelined request after a chunked body
+	pipelined := NewReader(&chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"3\r\nabc\r\n0\r\n\r\n" +
+			"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err = pipelined.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "abc", readBody(t, r))
+	r, err = pipelined.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+
+	// Test: malformed Content-Length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: five\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: signs and spaces are not part of a Content-Length
+	for _, value := range []string{"+3", "-3", "3 3", " "} {
+		_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: " + value + "\r\n\r\nabc"))
+		assert.Error(t, err, value)
+	}
+
+	// Test: invalid chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadAll()
+	assert.ErrorIs(t, err, ERROR_BAD_CHUNK)
+
+	// Test: chunk data longer than its size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadAll()
+	assert.ErrorIs(t, err, ERROR_BAD_CHUNK)
+
+	// Test: Transfer-Encoding and Content-Length together
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	assert.ErrorIs(t, err, ERROR_LENGTH_AND_TRANSFER_ENCODING)
+
+	// Test: unsupported transfer coding
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: gzip, chunked\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	assert.ErrorIs(t, err, ERROR_TRANSFER_ENCODING_NOT_SUPPORTED)
+}
+
+func TestStreamingBody(t *testing.T) {
+	// Test: body is read lazily from the connection
+	src := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 26\r\n" +
+			"\r\n" +
+			"abcdefghijklmnopqrstuvwxyz",
+		numBytesPerRead: 4,
+	}
+	r, err := RequestFromReader(src)
+	require.NoError(t, err)
+	assert.Less(t, src.pos, len(src.data))
+
+	buf := make([]byte, 10)
+	n, err := io.ReadFull(r.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefghij", string(buf[:n]))
+	assert.Equal(t, "klmnopqrstuvwxyz", readBody(t, r))
+
+	// Test: Content-Length over the limit is rejected up front
+	reader := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 26\r\n" +
+			"\r\n" +
+			"abcdefghijklmnopqrstuvwxyz",
+		numBytesPerRead: 4,
+	})
+	reader.MaxBodySize = 10
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+
+	// Test: chunked body over the limit fails while reading
+	reader = NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"8\r\nabcdefgh\r\n8\r\nijklmnop\r\n0\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	reader.MaxBodySize = 10
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = r.ReadAll()
+	assert.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_UNREAD_BODY)
+
+	// Test: closing an unread body skips it for the next request
+	reader = NewReader(&chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+
+	_, err = r.Body.Read(buf)
+	assert.ErrorIs(t, err, ERROR_BODY_CLOSED)
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+}
+
+func TestRequestSizeLimits(t *testing.T) {
+	longHeader := "X-Long: " + strings.Repeat("a", 3000) + "\r\n"
+
+	// Test: headers bigger than the initial buffer
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n" + longHeader + "\r\n",
+		numBytesPerRead: 512,
+	})
+	require.NoError(t, err)
+	assert.Len(t, r.Headers.Get("X-Long"), 3000)
+
+	// Test: request line too long
+	reader := NewReader(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 16,
+	})
+	reader.MaxRequestLineLength = 64
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_REQUEST_LINE_TOO_LONG)
+
+	// Test: request line too long without a line ending in sight
+	reader = NewReader(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 5000),
+		numBytesPerRead: 1024,
+	})
+	reader.MaxRequestLineLength = 2048
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_REQUEST_LINE_TOO_LONG)
+
+	// Test: header block too large
+	reader = NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n" + longHeader + longHeader + "\r\n",
+		numBytesPerRead: 512,
+	})
+	reader.MaxHeaderBytes = 4096
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+
+	// Test: too many header fields
+	reader = NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: a\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n",
+		numBytesPerRead: 1024,
+	})
+	reader.MaxHeaderCount = 3
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+
+	// Test: exactly at the header count limit
+	reader = NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: a\r\nB: 2\r\nC: 3\r\n\r\n",
+		numBytesPerRead: 1,
+	})
+	reader.MaxHeaderCount = 3
+	_, err = reader.ReadRequest()
+	assert.NoError(t, err)
+	// Test: trailers count against the same limits
+	chunked := "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n"
+
+	reader = NewReader(&chunkReader{
+		data:            chunked + strings.Repeat("X-T: 1\r\n", 20000) + "\r\n",
+		numBytesPerRead: 1024,
+	})
+	reader.MaxHeaderCount = 10
+	req, err := reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(req.Body)
+	assert.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+
+	reader = NewReader(&chunkReader{
+		data:            chunked + "X-T: " + strings.Repeat("a", 2000) + "\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	reader.MaxHeaderBytes = 1024
+	req, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(req.Body)
+	assert.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+
+	reader = NewReader(&chunkReader{
+		data:            chunked + "A: 1\r\nB: 2\r\nC: 3\r\n\r\n",
+		numBytesPerRead: 1,
+	})
+	reader.MaxHeaderCount = 3
+	req, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(req.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "3", req.Trailers.Get("C"))
+}
+
+func parseTargetLine(method, target string) (*Request, error) {
+	return RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+}
+
+func TestRequestTargetParse(t *testing.T) {
+	// Test: origin-form with a multi-value query
+	r, err := parseTargetLine("GET", "/search?q=go+http&tag=a&tag=b")
+	require.NoError(t, err)
+	assert.Equal(t, TargetOriginForm, r.URL.Form)
+	assert.Equal(t, "/search", r.URL.Path)
+	assert.Equal(t, "q=go+http&tag=a&tag=b", r.URL.RawQuery)
+	assert.Equal(t, "go http", r.URL.Query.Get("q"))
+	assert.Equal(t, []string{"a", "b"}, r.URL.Query["tag"])
+	assert.NoError(t, r.URL.QueryErr)
+
+	// Test: a bad query keeps the request and what could be parsed
+	r, err = parseTargetLine("GET", "/?a=1;b=2&c=3")
+	require.NoError(t, err)
+	assert.Equal(t, "a=1;b=2&c=3", r.URL.RawQuery)
+	assert.Equal(t, "3", r.URL.Query.Get("c"))
+	assert.Error(t, r.URL.QueryErr)
+
+	r, err = parseTargetLine("GET", "/?q=100%")
+	require.NoError(t, err)
+	assert.Equal(t, "q=100%", r.URL.RawQuery)
+	assert.Error(t, r.URL.QueryErr)
+
+	// Test: percent-encoding and dot-segments are normalized
+	r, err = parseTargetLine("GET", "/a/./b/../%7euser/%e2%9c%93%20x/")
+	require.NoError(t, err)
+	assert.Equal(t, "/a/~user/%E2%9C%93%20x/", r.URL.RawPath)
+	assert.Equal(t, "/a/~user/✓ x/", r.URL.Path)
+
+	// Test: encoded slashes are decoded in Path only
+	r, err = parseTargetLine("GET", "/files/a%2Fb")
+	require.NoError(t, err)
+	assert.Equal(t, "/files/a%2Fb", r.URL.RawPath)
+	assert.Equal(t, "/files/a/b", r.URL.Path)
+
+	// Test: absolute-form
+	r, err = parseTargetLine("GET", "http://example.com:8080?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, TargetAbsoluteForm, r.URL.Form)
+	assert.Equal(t, "http", r.URL.Scheme)
+	assert.Equal(t, "example.com:8080", r.URL.Authority)
+	assert.Equal(t, "/", r.URL.Path)
+	assert.Equal(t, "1", r.URL.Query.Get("x"))
+
+	// Test: authority-form for CONNECT
+	r, err = parseTargetLine("CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, TargetAuthorityForm, r.URL.Form)
+	assert.Equal(t, "example.com:443", r.URL.Authority)
+
+	// Test: asterisk-form for OPTIONS
+	r, err = parseTargetLine("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, TargetAsteriskForm, r.URL.Form)
+
+	// Test: invalid targets
+	for _, test := range []struct{ method, target string }{
+		{"GET", "*"},
+		{"GET", "example.com:443"},
+		{"CONNECT", "/path"},
+		{"GET", "ftp://example.com/"},
+		{"GET", "http://user@example.com/"},
+		{"GET", "/bad%zzescape"},
+		{"GET", "/nul%00byte"},
+	} {
+		_, err = parseTargetLine(test.method, test.target)
+		assert.ErrorIs(t, err, ERROR_BAD_REQUEST_TARGET, test.target)
+	}
+
+	// Test: path traversal
+	for _, target := range []string{"/../etc/passwd", "/a/../../etc", "/%2e%2e/etc", "/a/..%2F..%2Fetc"} {
+		_, err = parseTargetLine("GET", target)
+		assert.ErrorIs(t, err, ERROR_PATH_TRAVERSAL, target)
+	}
+}
+
+func TestHostHeader(t *testing.T) {
+	// Test: Host is lowercased
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: Example.TEST:8080\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "example.test:8080", r.Host)
+
+	// Test: absolute-form authority takes precedence
+	r, err = RequestFromReader(strings.NewReader("GET http://api.example.test/ HTTP/1.1\r\nHost: other\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "api.example.test", r.Host)
+
+	// Test: HTTP/1.0 may leave Host out
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "", r.Host)
+
+	// Test: empty Host is allowed
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost:\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: missing, duplicate and invalid Host
+	for _, head := range []string{
+		"GET / HTTP/1.1\r\n",
+		"GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n",
+		"GET / HTTP/1.1\r\nHost: a, b\r\n",
+		"GET / HTTP/1.1\r\nHost: a/b\r\n",
+		"GET / HTTP/1.1\r\nHost: user@a\r\n",
+	} {
+		_, err = RequestFromReader(strings.NewReader(head + "\r\n"))
+		assert.ErrorIs(t, err, ERROR_BAD_HOST, head)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	// Test: the continue func runs once, on the first read
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nExpect: 100-Continue\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+
+	calls := 0
+	r.SetContinueFunc(func() error {
+		calls++
+		return nil
+	})
+
+	assert.Equal(t, "hello", readBody(t, r))
+	assert.Equal(t, 1, calls)
+
+	// Test: HTTP/1.0 expectations are ignored
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nExpect: magic\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: unsupported expectation
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nExpect: magic\r\n\r\n"))
+	assert.ErrorIs(t, err, ERROR_EXPECTATION_FAILED)
+}
+
+func encodedRequest(t *testing.T, encoding string, body []byte) *Request {
+	head := fmt.Sprintf("POST / HTTP/1.1\r\nHost: a\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n", encoding, len(body))
+
+	r, err := RequestFromReader(strings.NewReader(head + string(body)))
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestDecodeBody(t *testing.T) {
+	text := strings.Repeat("hello ", 100)
+
+	gzipped := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzipped)
+	gw.Write([]byte(text))
+	gw.Close()
+
+	deflated := &bytes.Buffer{}
+	zw := zlib.NewWriter(deflated)
+	zw.Write(gzipped.Bytes())
+	zw.Close()
+
+	// Test: gzip body
+	r := encodedRequest(t, "gzip", gzipped.Bytes())
+	require.NoError(t, r.DecodeBody(0))
+	assert.False(t, r.Headers.Contains("Content-Encoding"))
+	assert.False(t, r.Headers.Contains("Content-Length"))
+	assert.Equal(t, text, readBody(t, r))
+
+	// Test: codings are undone in reverse order
+	r = encodedRequest(t, "gzip, deflate", deflated.Bytes())
+	require.NoError(t, r.DecodeBody(0))
+	assert.Equal(t, text, readBody(t, r))
+
+	// Test: decoded size limit
+	r = encodedRequest(t, "gzip", gzipped.Bytes())
+	require.NoError(t, r.DecodeBody(100))
+	body, err := r.ReadAll()
+	assert.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+	assert.Len(t, body, 100)
+
+	// Test: unsupported coding
+	r = encodedRequest(t, "br", []byte("data"))
+	assert.ErrorIs(t, r.DecodeBody(0), ERROR_UNSUPPORTED_CONTENT_ENCODING)
+	assert.Equal(t, "br", r.Headers.Get("Content-Encoding"))
+
+	// Test: corrupt data
+	r = encodedRequest(t, "gzip", []byte("not gzip"))
+	require.NoError(t, r.DecodeBody(0))
+	_, err = r.ReadAll()
+	assert.Error(t, err)
+}
+
+func TestCookies(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\nCookie: session=abc; theme=dark\r\nCookie: lang=en\r\n\r\n"))
+	require.NoError(t, err)
+
+	assert.Len(t, r.Cookies(), 3)
+
+	c, err := r.Cookie("lang")
+	require.NoError(t, err)
+	assert.Equal(t, "en", c.Value)
+
+	_, err = r.Cookie("missing")
+	assert.ErrorIs(t, err, ERROR_NO_COOKIE)
+}
+
+func TestEventSourceRequest(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET /events HTTP/1.1\r\nHost: a\r\nLast-Event-ID: 42\r\n\r\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "42", r.LastEventID())
+
+	// Test: requests read outside a server never end
+	assert.NotNil(t, r.Context())
+	assert.Nil(t, r.Context().Done())
+}
+
+func TestURLEncodedForm(t *testing.T) {
+	body := "name=Jane+Doe&tag=a&tag=b"
+	r, err := RequestFromReader(strings.NewReader(fmt.Sprintf(
+		"POST /submit?tag=q&page=2 HTTP/1.1\r\nHost: a\r\nContent-Type: application/x-www-form-urlencoded; charset=utf-8\r\nContent-Length: %d\r\n\r\n%s",
+		len(body), body)))
+	require.NoError(t, err)
+
+	// Test: body values come before query values
+	assert.Equal(t, "Jane Doe", r.FormValue("name"))
+	assert.Equal(t, "2", r.FormValue("page"))
+	assert.Equal(t, []string{"a", "b", "q"}, r.Form["tag"])
+
+	// Test: the body is gone
+	_, err = r.MultipartReader(0)
+	assert.ErrorIs(t, err, ERROR_FORM_ALREADY_READ)
+
+	// Test: size limit
+	big := "field=" + strings.Repeat("x", DEFAULT_MAX_FORM_SIZE)
+	r, err = RequestFromReader(strings.NewReader(fmt.Sprintf(
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: %d\r\n\r\n%s",
+		len(big), big)))
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.ParseForm(), ERROR_FORM_TOO_LARGE)
+}
+
+func multipartRequest(t *testing.T) *Request {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+
+	require.NoError(t, mw.WriteField("title", "report"))
+
+	fw, err := mw.CreateFormFile("upload", "data.txt")
+	require.NoError(t, err)
+	fw.Write([]byte(strings.Repeat("file data ", 100)))
+
+	require.NoError(t, mw.Close())
+
+	r, err := RequestFromReader(strings.NewReader(fmt.Sprintf(
+		"POST /?title=query HTTP/1.1\r\nHost: a\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s",
+		mw.FormDataContentType(), buf.Len(), buf)))
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestMultipartForm(t *testing.T) {
+	// Test: fields and files, big files spill to disk
+	r := multipartRequest(t)
+	require.NoError(t, r.ParseMultipartForm(100, 0))
+	defer r.RemoveFormFiles()
+
+	assert.Equal(t, "report", r.FormValue("title"))
+	assert.Equal(t, []string{"report", "query"}, r.Form["title"])
+
+	file, header, err := r.FormFile("upload")
+	require.NoError(t, err)
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "data.txt", header.Filename)
+	assert.Equal(t, int64(1000), header.Size)
+	assert.Equal(t, strings.Repeat("file data ", 100), string(data))
+
+	_, _, err = r.FormFile("missing")
+	assert.ErrorIs(t, err, ERROR_MISSING_FILE)
+
+	// Test: streaming parts with their headers
+	r = multipartRequest(t)
+	reader, err := r.MultipartReader(0)
+	require.NoError(t, err)
+
+	part, err := reader.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+
+	part, err = reader.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "application/octet-stream", part.Header.Get("Content-Type"))
+	assert.Equal(t, "data.txt", part.FileName())
+
+	_, err = reader.NextPart()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: total size limit
+	r = multipartRequest(t)
+	assert.ErrorIs(t, r.ParseMultipartForm(100, 500), ERROR_FORM_TOO_LARGE)
+
+	// Test: not multipart
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nContent-Type: text/plain\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = r.MultipartReader(0)
+	assert.ErrorIs(t, err, ERROR_NOT_MULTIPART)
+}
+
+func jsonRequest(t *testing.T, contentType, body string) *Request {
+	r, err := RequestFromReader(strings.NewReader(fmt.Sprintf(
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s",
+		contentType, len(body), body)))
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type message struct {
+		Text  string `json:"text"`
+		Count int    `json:"count"`
+	}
+
+	var m message
+
+	// Test: valid body, +json types are accepted
+	require.NoError(t, DecodeJSON(jsonRequest(t, "application/json; charset=utf-8", `{"text":"hi","count":2}`+"\n"), &m))
+	assert.Equal(t, message{Text: "hi", Count: 2}, m)
+
+	require.NoError(t, DecodeJSON(jsonRequest(t, "application/merge-patch+json", `{"text":"patch"}`), &m))
+	assert.Equal(t, "patch", m.Text)
+
+	// Test: wrong content type
+	assert.ErrorIs(t, DecodeJSON(jsonRequest(t, "text/plain", `{}`), &m), ERROR_NOT_JSON)
+
+	// Test: unknown fields, trailing data, malformed and empty bodies
+	for _, body := range []string{`{"text":"hi","extra":1}`, `{}{}`, `{"text":`, ``, `{"count":"x"}`} {
+		assert.ErrorIs(t, DecodeJSON(jsonRequest(t, "application/json", body), &m), ERROR_INVALID_JSON, body)
+	}
+
+	// Test: size limit
+	err := DecodeJSONWithLimit(jsonRequest(t, "application/json", `{"text":"`+strings.Repeat("x", 100)+`"}`), &m, 50)
+	assert.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+}
@@ -0,0 +1,267 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type TargetForm int
+
+// The four request-target forms of RFC 9112 3.2.
+const (
+	TargetOriginForm    TargetForm = 0 // /path?query
+	TargetAbsoluteForm  TargetForm = 1 // http://host/path?query
+	TargetAuthorityForm TargetForm = 2 // host:port, CONNECT only
+	TargetAsteriskForm  TargetForm = 3 // *, OPTIONS only
+)
+
+var ERROR_BAD_REQUEST_TARGET = fmt.Errorf("Invalid request target")
+var ERROR_PATH_TRAVERSAL = fmt.Errorf("Request path escapes the root")
+
+// URL is the parsed request-target.
+type URL struct {
+	Form TargetForm
+	// Scheme is only set for the absolute-form
+	Scheme string
+	// Authority is the host and optional port of the absolute-form and
+	// authority-form
+	Authority string
+	// RawPath is the path with percent-encoding and dot-segments
+	// normalized but still escaped
+	RawPath string
+	// Path is RawPath decoded
+	Path     string
+	RawQuery string
+	// Query holds the parameters that could be parsed, QueryErr tells why
+	// the others were skipped
+	Query    url.Values
+	QueryErr error
+}
+
+func parseTarget(method, target string) (URL, error) {
+	switch {
+	case target == "*":
+		if method != "OPTIONS" {
+			return URL{}, ERROR_BAD_REQUEST_TARGET
+		}
+
+		return URL{Form: TargetAsteriskForm, Query: url.Values{}}, nil
+
+	case method == "CONNECT":
+		if !isAuthority(target) {
+			return URL{}, ERROR_BAD_REQUEST_TARGET
+		}
+
+		return URL{Form: TargetAuthorityForm, Authority: target, Query: url.Values{}}, nil
+
+	case strings.HasPrefix(target, "/"):
+		u := URL{Form: TargetOriginForm}
+
+		return u, u.setPathAndQuery(target)
+	}
+
+	scheme, rest, found := strings.Cut(target, "://")
+
+	if !found || !strings.EqualFold(scheme, "http") && !strings.EqualFold(scheme, "https") {
+		return URL{}, ERROR_BAD_REQUEST_TARGET
+	}
+
+	authority := rest
+	pathAndQuery := "/"
+
+	if idx := strings.IndexAny(rest, "/?"); idx != -1 {
+		authority = rest[:idx]
+		pathAndQuery = rest[idx:]
+
+		if strings.HasPrefix(pathAndQuery, "?") {
+			pathAndQuery = "/" + pathAndQuery
+		}
+	}
+
+	if authority == "" || strings.Contains(authority, "@") || !isAuthority(authority) && !isHost(authority) {
+		return URL{}, ERROR_BAD_REQUEST_TARGET
+	}
+
+	u := URL{
+		Form:      TargetAbsoluteForm,
+		Scheme:    strings.ToLower(scheme),
+		Authority: authority,
+	}
+
+	return u, u.setPathAndQuery(pathAndQuery)
+}
+
+func (u *URL) setPathAndQuery(target string) error {
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+
+	if strings.Contains(rawQuery, "#") || strings.Contains(rawPath, "#") {
+		return ERROR_BAD_REQUEST_TARGET
+	}
+
+	normalized, err := normalizePath(rawPath)
+
+	if err != nil {
+		return err
+	}
+
+	path, err := url.PathUnescape(normalized)
+
+	if err != nil {
+		return ERROR_BAD_REQUEST_TARGET
+	}
+
+	// dot-segments or NUL that only show up after decoding were hidden
+	// behind encoded slashes, as in /..%2F..%2Fetc/passwd
+	if strings.ContainsRune(path, 0) {
+		return ERROR_BAD_REQUEST_TARGET
+	}
+
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "." || seg == ".." {
+			return ERROR_PATH_TRAVERSAL
+		}
+	}
+
+	u.RawPath = normalized
+	u.Path = path
+	u.RawQuery = rawQuery
+
+	// a bad parameter is for the handler to judge, not the whole request
+	u.Query, u.QueryErr = url.ParseQuery(rawQuery)
+
+	return nil
+}
+
+// normalizePath upper-cases percent-encodings, decodes the ones of
+// unreserved characters and removes dot-segments (RFC 3986 6.2.2). Paths
+// climbing above the root are rejected.
+func normalizePath(rawPath string) (string, error) {
+	var builder strings.Builder
+
+	for i := 0; i < len(rawPath); i++ {
+		ch := rawPath[i]
+
+		if ch != '%' {
+			if ch <= ' ' || ch == 0x7f {
+				return "", ERROR_BAD_REQUEST_TARGET
+			}
+
+			builder.WriteByte(ch)
+			continue
+		}
+
+		if i+2 >= len(rawPath) || !isHex(rawPath[i+1]) || !isHex(rawPath[i+2]) {
+			return "", ERROR_BAD_REQUEST_TARGET
+		}
+
+		decoded := unhex(rawPath[i+1])<<4 | unhex(rawPath[i+2])
+
+		if isUnreserved(decoded) {
+			builder.WriteByte(decoded)
+		} else {
+			builder.WriteString(strings.ToUpper(rawPath[i : i+3]))
+		}
+
+		i += 2
+	}
+
+	segments := strings.Split(builder.String(), "/")[1:]
+	output := make([]string, 0, len(segments))
+
+	for i, seg := range segments {
+		last := i == len(segments)-1
+
+		switch seg {
+		case ".":
+			if last {
+				output = append(output, "")
+			}
+
+		case "..":
+			if len(output) == 0 {
+				return "", ERROR_PATH_TRAVERSAL
+			}
+
+			output = output[:len(output)-1]
+
+			if last {
+				output = append(output, "")
+			}
+
+		default:
+			output = append(output, seg)
+		}
+	}
+
+	return "/" + strings.Join(output, "/"), nil
+}
+
+// isAuthority reports whether s looks like host:port.
+func isAuthority(s string) bool {
+	idx := strings.LastIndex(s, ":")
+
+	if idx <= 0 || idx == len(s)-1 {
+		return false
+	}
+
+	for _, ch := range s[idx+1:] {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+
+	return isHost(s[:idx])
+}
+
+// isHost reports whether s is a reg-name, IPv4 address or bracketed IPv6
+// address.
+func isHost(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	if strings.HasPrefix(s, "[") {
+		if !strings.HasSuffix(s, "]") {
+			return false
+		}
+
+		for _, ch := range s[1 : len(s)-1] {
+			if !(isHex(byte(ch)) || ch == ':' || ch == '.') {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+
+		if !isUnreserved(ch) && !strings.ContainsRune("!$&'()*+,;=%", rune(ch)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isUnreserved(ch byte) bool {
+	return ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' ||
+		ch == '-' || ch == '.' || ch == '_' || ch == '~'
+}
+
+func isHex(ch byte) bool {
+	return ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F'
+}
+
+func unhex(ch byte) byte {
+	switch {
+	case ch >= '0' && ch <= '9':
+		return ch - '0'
+	case ch >= 'a' && ch <= 'f':
+		return ch - 'a' + 10
+	default:
+		return ch - 'A' + 10
+	}
+}
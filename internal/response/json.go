@@ -0,0 +1,97 @@
+package response
+
+import (
+	"encoding/json"
+	"maps"
+)
+
+// JSON sends v encoded as JSON with a Content-Type of application/json.
+// Nothing is written if v can't be encoded.
+func JSON(w ResponseWriter, statusCode StatusCode, v any) error {
+	body, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return SendBodyWithDefaultHeaders(w, statusCode, append(body, '\n'))
+}
+
+// Problem is an RFC 9457 problem details object, the JSON body of error
+// responses.
+type Problem struct {
+	// Type is a URI identifying the kind of problem, left out it means
+	// "about:blank": nothing more than the status code
+	Type     string
+	Title    string
+	Status   StatusCode
+	Detail   string
+	Instance string
+
+	// Extensions are extra members of the object, they can't replace the
+	// standard ones
+	Extensions map[string]any
+}
+
+// NewProblem returns a Problem for statusCode titled with its reason
+// phrase.
+func NewProblem(statusCode StatusCode, detail string) *Problem {
+	return &Problem{
+		Title:  StatusText(statusCode),
+		Status: statusCode,
+		Detail: detail,
+	}
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	object := maps.Clone(p.Extensions)
+
+	if object == nil {
+		object = map[string]any{}
+	}
+
+	members := map[string]string{
+		"type":     p.Type,
+		"title":    p.Title,
+		"detail":   p.Detail,
+		"instance": p.Instance,
+	}
+
+	for name, value := range members {
+		delete(object, name)
+
+		if value != "" {
+			object[name] = value
+		}
+	}
+
+	delete(object, "status")
+
+	if p.Status != 0 {
+		object["status"] = p.Status
+	}
+
+	return json.Marshal(object)
+}
+
+// SendProblem sends p as application/problem+json with p.Status, or 500
+// if it has none.
+func SendProblem(w ResponseWriter, p *Problem) error {
+	body, err := json.Marshal(p)
+
+	if err != nil {
+		return err
+	}
+
+	statusCode := p.Status
+
+	if statusCode == 0 {
+		statusCode = HTTP_STATUS_INTERNAL_SERVER_ERROR
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+
+	return SendBodyWithDefaultHeaders(w, statusCode, append(body, '\n'))
+}
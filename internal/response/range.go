@@ -0,0 +1,277 @@
+package response
+
+import (
+	"crypto/rand"
+	"fmt"
+	"go-http/internal/headers"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ERROR_INVALID_RANGE = fmt.Errorf("Invalid Range header")
+var ERROR_RANGE_NOT_SATISFIABLE = fmt.Errorf("Range not satisfiable")
+
+// MAX_RANGES is the most ranges a single request may ask for, requests
+// with more get the whole content.
+const MAX_RANGES = 100
+
+// ByteRange is a part of a representation, Length bytes from Start.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+// ContentRange formats the Content-Range value of r within size bytes.
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a Range header value for content of size bytes
+// (RFC 9110 14.2). Ranges past the end are dropped and the rest clamped to
+// size. It returns ERROR_RANGE_NOT_SATISFIABLE if no range is left and
+// ERROR_INVALID_RANGE for values that should be ignored: other units,
+// syntax errors and overlapping ranges adding up to more than the content.
+func ParseRange(value string, size int64) ([]ByteRange, error) {
+	unit, set, found := strings.Cut(value, "=")
+
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, ERROR_INVALID_RANGE
+	}
+
+	specs := strings.Split(set, ",")
+
+	if len(specs) > MAX_RANGES {
+		return nil, ERROR_INVALID_RANGE
+	}
+
+	var ranges []ByteRange
+	var total int64
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+
+		if spec == "" {
+			continue
+		}
+
+		first, last, found := strings.Cut(spec, "-")
+
+		if !found {
+			return nil, ERROR_INVALID_RANGE
+		}
+
+		var r ByteRange
+
+		if first == "" {
+			// suffix range: the last n bytes
+			n, err := parseRangeInt(last)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if n == 0 || size == 0 {
+				continue
+			}
+
+			n = min(n, size)
+			r = ByteRange{Start: size - n, Length: n}
+		} else {
+			start, err := parseRangeInt(first)
+
+			if err != nil {
+				return nil, err
+			}
+
+			end := size - 1
+
+			if last != "" {
+				end, err = parseRangeInt(last)
+
+				if err != nil || end < start {
+					return nil, ERROR_INVALID_RANGE
+				}
+
+				end = min(end, size-1)
+			}
+
+			if start >= size {
+				continue
+			}
+
+			r = ByteRange{Start: start, Length: end - start + 1}
+		}
+
+		ranges = append(ranges, r)
+		total += r.Length
+	}
+
+	if len(ranges) == 0 {
+		return nil, ERROR_RANGE_NOT_SATISFIABLE
+	}
+
+	if total > size {
+		return nil, ERROR_INVALID_RANGE
+	}
+
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, ERROR_INVALID_RANGE
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+
+	if err != nil {
+		return 0, ERROR_INVALID_RANGE
+	}
+
+	return n, nil
+}
+
+// SendContent sends content with support for Range requests. The Range
+// and If-Range headers of the request decide between a 200 with the whole
+// content, a 206 with one range, a 206 multipart/byteranges with several,
+// and a 416. If-Range is checked against the ETag and Last-Modified
+// already set on w.
+func SendContent(w ResponseWriter, reqHeaders *headers.Headers, content io.ReadSeeker) error {
+	size, err := content.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Del("Transfer-Encoding")
+
+	var ranges []ByteRange
+
+	if reqHeaders.Contains("Range") && ifRangeMatches(w.Header(), reqHeaders) {
+		ranges, err = ParseRange(reqHeaders.Get("Range"), size)
+
+		if err == ERROR_RANGE_NOT_SATISFIABLE {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return SendEmptyResponse(w, HTTP_STATUS_RANGE_NOT_SATISFIABLE)
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		return sendRange(w, HTTP_STATUS_OK, content, ByteRange{Start: 0, Length: size})
+
+	case 1:
+		w.Header().Set("Content-Range", ranges[0].ContentRange(size))
+
+		return sendRange(w, HTTP_STATUS_PARTIAL_CONTENT, content, ranges[0])
+
+	default:
+		return sendMultipartRanges(w, content, ranges, size)
+	}
+}
+
+func sendRange(w ResponseWriter, statusCode StatusCode, content io.ReadSeeker, r ByteRange) error {
+	w.Header().Set("Content-Length", strconv.FormatInt(r.Length, 10))
+
+	if err := w.WriteHeader(statusCode); err != nil {
+		return err
+	}
+
+	// no need to read what HEAD drops
+	if HeadOnly(w) {
+		return nil
+	}
+
+	if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err := io.CopyN(w, content, r.Length)
+
+	return err
+}
+
+// sendMultipartRanges sends ranges as a multipart/byteranges body (RFC 9110
+// 14.6). Every part carries its own Content-Type and Content-Range.
+func sendMultipartRanges(w ResponseWriter, content io.ReadSeeker, ranges []ByteRange, size int64) error {
+	boundary := rand.Text()
+	contentType := w.Header().Get("Content-Type")
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	partHeaders := make([]string, len(ranges))
+	length := int64(0)
+
+	for i, r := range ranges {
+		partHeaders[i] = fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			boundary, contentType, r.ContentRange(size))
+		length += int64(len(partHeaders[i])) + r.Length
+	}
+
+	closing := "\r\n--" + boundary + "--\r\n"
+	length += int64(len(closing))
+
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+
+	if err := w.WriteHeader(HTTP_STATUS_PARTIAL_CONTENT); err != nil {
+		return err
+	}
+
+	if HeadOnly(w) {
+		return nil
+	}
+
+	for i, r := range ranges {
+		if _, err := io.WriteString(w, partHeaders[i]); err != nil {
+			return err
+		}
+
+		if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+			return err
+		}
+
+		if _, err := io.CopyN(w, content, r.Length); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, closing)
+
+	return err
+}
+
+// ifRangeMatches reports whether the Range header applies: there is no
+// If-Range, or it names the current representation by strong ETag or
+// exact Last-Modified date (RFC 9110 13.1.5).
+func ifRangeMatches(respHeaders *headers.Headers, reqHeaders *headers.Headers) bool {
+	if !reqHeaders.Contains("If-Range") {
+		return true
+	}
+
+	ifRange := strings.TrimSpace(reqHeaders.Get("If-Range"))
+
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == respHeaders.Get("ETag")
+	}
+
+	if strings.HasPrefix(ifRange, "W/") || !respHeaders.Contains("Last-Modified") {
+		return false
+	}
+
+	since, err := time.Parse(time.RFC1123, ifRange)
+	modified, modErr := time.Parse(time.RFC1123, respHeaders.Get("Last-Modified"))
+
+	return err == nil && modErr == nil && since.Equal(modified)
+}
@@ -0,0 +1,51 @@
+package response
+
+// StatusRecorder wraps a ResponseWriter and remembers the status code and
+// the number of body bytes written through it, for middleware that logs or
+// inspects responses.
+type StatusRecorder struct {
+	ResponseWriter
+	statusCode   StatusCode
+	bytesWritten int
+}
+
+func NewStatusRecorder(w ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w}
+}
+
+func (r *StatusRecorder) WriteHeader(statusCode StatusCode) error {
+	err := r.ResponseWriter.WriteHeader(statusCode)
+
+	// interim 1xx responses are not the status of the response
+	if err == nil && statusCode >= 200 {
+		r.statusCode = statusCode
+	}
+
+	return err
+}
+
+func (r *StatusRecorder) Write(data []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = HTTP_STATUS_OK
+	}
+
+	n, err := r.ResponseWriter.Write(data)
+	r.bytesWritten += n
+
+	return n, err
+}
+
+// StatusCode returns the status written so far, or 0 if the handler wrote
+// nothing. The server sends an empty 200 in that case.
+func (r *StatusRecorder) StatusCode() StatusCode {
+	return r.statusCode
+}
+
+func (r *StatusRecorder) BytesWritten() int {
+	return r.bytesWritten
+}
+
+// Unwrap returns the wrapped ResponseWriter.
+func (r *StatusRecorder) Unwrap() ResponseWriter {
+	return r.ResponseWriter
+}
@@ -1,20 +1,27 @@
 package response
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"go-http/internal/headers"
 	"io"
 	"strconv"
+	"time"
 )
 
-type StatusCode int
-
-const (
-	HTTP_STATUS_OK                    StatusCode = 200
-	HTTP_STATUS_BAD_REQUEST           StatusCode = 400
-	HTTP_STATUS_INTERNAL_SERVER_ERROR StatusCode = 500
-)
+// ResponseWriter is what a handler uses to build its response. Headers set
+// through Header() are sent by the first call to WriteHeader or Write,
+// later changes are ignored. Write streams the body, calling WriteHeader
+// with 200 first if no status was written yet. A 1xx status is sent as an
+// interim response with the headers set so far, the final status still has
+// to follow.
+type ResponseWriter interface {
+	Header() *headers.Headers
+	// Trailer holds the trailer fields sent after a chunked body. Their
+	// names should be announced in a "Trailer" header.
+	Trailer() *headers.Headers
+	WriteHeader(statusCode StatusCode) error
+	Write(data []byte) (int, error)
+}
 
 type WriterState int
 
@@ -22,135 +29,310 @@ const (
 	WriteStatusLine WriterState = 0
 	WriteHeaders    WriterState = 1
 	WriteBody       WriterState = 2
-	WriteTrailers   WriterState = 2
-	WriteDone       WriterState = 3
+	WriteTrailers   WriterState = 3
+	WriteDone       WriterState = 4
 )
 
-type ResponseWriter struct {
-	writer     io.Writer
-	statusCode StatusCode
-	headers    headers.Headers
-	body       []byte
-	state      WriterState
-	trailers   headers.Headers
+var ERROR_HEADER_ALREADY_WRITTEN = fmt.Errorf("Response header already written")
+var ERROR_RESPONSE_FINISHED = fmt.Errorf("Response already finished")
+var ERROR_BODY_NOT_ALLOWED = fmt.Errorf("Response status does not allow a body")
+var ERROR_CONTENT_LENGTH_EXCEEDED = fmt.Errorf("Response body longer than Content-Length")
+var ERROR_CONTENT_LENGTH_MISMATCH = fmt.Errorf("Response body shorter than Content-Length")
+var ERROR_SWITCHING_PROTOCOLS = fmt.Errorf("Switching protocols is not supported")
+
+// Writer is the ResponseWriter writing HTTP/1.1 responses to a connection.
+// Bodies are sent with the Content-Length from the headers when one is set
+// and chunked otherwise. HTTP/1.0 clients don't understand chunked bodies,
+// theirs end by closing the connection instead.
+type Writer struct {
+	writer        io.Writer
+	beforeHeader  func()
+	statusCode    StatusCode
+	reasonPhrase  string
+	headers       headers.Headers
+	trailers      headers.Headers
+	state         WriterState
+	chunked       bool
+	http10        bool
+	headOnly      bool
+	untilClose    bool
+	noBody        bool
+	contentLength int
+	written       int
 }
 
-func NewResponseWriter(writer io.Writer) *ResponseWriter {
-	return &ResponseWriter{
-		writer:  writer,
-		headers: headers.GetDefaultHeaders(0),
-		state:   WriteStatusLine,
+func NewResponseWriter(writer io.Writer) *Writer {
+	return &Writer{
+		writer:        writer,
+		headers:       *headers.NewHeaders(),
+		trailers:      *headers.NewHeaders(),
+		state:         WriteStatusLine,
+		contentLength: -1,
 	}
 }
 
-func (w *ResponseWriter) SetStatusCode(statusCode StatusCode) {
-	w.statusCode = statusCode
+// SetHTTP10 marks the response as going to an HTTP/1.0 client. It must be
+// called before the header is written.
+func (w *Writer) SetHTTP10() {
+	w.http10 = true
 }
 
-func (w *ResponseWriter) SetHeader(key, value string) {
-	w.headers.Set(key, value)
+// SetHeadOnly marks the response as the answer to a HEAD request. Headers
+// are sent as they would be for GET, but body writes are discarded.
+func (w *Writer) SetHeadOnly() {
+	w.headOnly = true
 }
 
-func (w *ResponseWriter) SetBody(body []byte) {
-	w.body = body
+func (w *Writer) HeadOnly() bool {
+	return w.headOnly
 }
 
-func (w ResponseWriter) Send(statusCode StatusCode, hdrs headers.Headers, body []byte) {
-	w.SetStatusCode(statusCode)
+// SetWriteDeadline sets the write deadline of the connection below, if it
+// has one. A zero time means no deadline.
+func (w *Writer) SetWriteDeadline(t time.Time) error {
+	if conn, ok := w.writer.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return conn.SetWriteDeadline(t)
+	}
 
-	w.headers.Extend(hdrs)
-	w.headers.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
 
-	w.SetBody(body)
-	w.writeAll()
+// BeforeWriteHeader sets fn to run right before the final status is
+// written, while the headers can still be changed.
+func (w *Writer) BeforeWriteHeader(fn func()) {
+	w.beforeHeader = fn
 }
 
-func (w ResponseWriter) SendBodyWithDefaultHeaders(statusCode StatusCode, body []byte) {
-	w.SetStatusCode(statusCode)
+func (w *Writer) Header() *headers.Headers {
+	return &w.headers
+}
 
-	w.headers.Set("Content-Length", strconv.Itoa(len(body)))
+func (w *Writer) Trailer() *headers.Headers {
+	return &w.trailers
+}
 
-	w.SetBody(body)
-	w.writeAll()
+// SetReasonPhrase overrides the registered reason phrase sent with the
+// status code. Phrases with characters not allowed in a status line are
+// ignored.
+func (w *Writer) SetReasonPhrase(reasonPhrase string) {
+	w.reasonPhrase = reasonPhrase
 }
 
-func (w ResponseWriter) SendEmptyResponse(statusCode StatusCode) {
-	w.SetStatusCode(statusCode)
-	w.writeAll()
+// StatusCode returns the status written so far, or 0 before WriteHeader.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
 }
 
-func (w ResponseWriter) SendFromStream(
-	statusCode StatusCode,
-	reader io.ReadCloser) {
+// HeaderWritten reports whether the status line and headers were sent.
+func (w *Writer) HeaderWritten() bool {
+	return w.state != WriteStatusLine
+}
 
-	// write status line
-	w.SetStatusCode(statusCode)
-	w.writeStatusLine()
+func (w *Writer) WriteHeader(statusCode StatusCode) error {
+	if w.state == WriteDone {
+		return ERROR_RESPONSE_FINISHED
+	}
 
-	// write headers
-	w.headers.Delete("Content-Length")
-	w.headers.Set("Transfer-Encoding", "chunked")
-	w.headers.Set("Content-Type", "text/plain")
-	w.headers.Set("Trailers", "X-Content-SHA256, X-Content-Length")
-	w.writeHeaders()
+	if w.state != WriteStatusLine {
+		return ERROR_HEADER_ALREADY_WRITTEN
+	}
 
-	// write body
-	data := make([]byte, 1024)
-	hasher := sha256.New()
-	contentLen := 0
+	if !isValidStatusCode(statusCode) {
+		return fmt.Errorf("Invalid status code: %d", statusCode)
+	}
+
+	// 1xx responses are interim, the final status still has to follow
+	if statusCode == HTTP_STATUS_SWITCHING_PROTOCOLS {
+		return ERROR_SWITCHING_PROTOCOLS
+	}
 
-	for {
-		n, err := reader.Read(data)
+	if statusCode < 200 {
+		return w.writeInterim(statusCode, w.headers.ToString())
+	}
 
-		if err != nil {
-			fmt.Println(err)
-			break
+	if w.beforeHeader != nil {
+		w.beforeHeader()
+	}
+
+	w.statusCode = statusCode
+
+	if err := w.prepareHeaders(); err != nil {
+		return err
+	}
+
+	if err := w.writeStatusLine(); err != nil {
+		return err
+	}
+
+	return w.writeHeaders()
+}
+
+// WriteContinue sends a "100 Continue" interim response, telling a client
+// that sent "Expect: 100-continue" to go on with the body. It does nothing
+// once the final status is written.
+func (w *Writer) WriteContinue() error {
+	if w.state != WriteStatusLine {
+		return nil
+	}
+
+	return w.writeInterim(HTTP_STATUS_CONTINUE, "\r\n")
+}
+
+// writeInterim sends a 1xx response with the given header block. HTTP/1.0
+// clients don't expect them, so nothing is sent to those.
+func (w *Writer) writeInterim(statusCode StatusCode, headerString string) error {
+	if w.http10 {
+		return nil
+	}
+
+	_, err := fmt.Fprintf(w.writer, "HTTP/1.1 %d %s\r\n%s", statusCode, StatusText(statusCode), headerString)
+
+	return err
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	if w.state == WriteStatusLine {
+		if err := w.WriteHeader(HTTP_STATUS_OK); err != nil {
+			return 0, err
 		}
+	}
 
-		chunk := data[:n]
+	if w.state != WriteBody {
+		return 0, ERROR_RESPONSE_FINISHED
+	}
 
-		w.writeBodyFromByteArray([]byte(fmt.Sprintf("%x\r\n", n)))
-		w.writeBodyFromByteArray(chunk)
-		w.writeBodyFromByteArray([]byte("\r\n"))
+	if len(data) == 0 {
+		return 0, nil
+	}
 
-		hasher.Write(chunk)
-		contentLen += n
+	if w.noBody {
+		return 0, ERROR_BODY_NOT_ALLOWED
 	}
 
-	w.writeBodyFromByteArray([]byte("0\r\n\r\n"))
+	if w.headOnly {
+		return len(data), nil
+	}
 
-	trailers := headers.NewHeaders()
+	if w.chunked {
+		return w.writeChunk(data)
+	}
 
-	sum := hasher.Sum(nil)
-	trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", sum))
-	trailers.Set("X-Content-Length", strconv.Itoa(contentLen))
+	if w.untilClose {
+		n, err := w.writer.Write(data)
+		w.written += n
 
-	w.state = WriteTrailers
-	w.trailers = *trailers
+		return n, err
+	}
+
+	var err error
+
+	if w.written+len(data) > w.contentLength {
+		data = data[:w.contentLength-w.written]
+		err = ERROR_CONTENT_LENGTH_EXCEEDED
+	}
+
+	n, writeErr := w.writer.Write(data)
+	w.written += n
 
-	w.writeTrailers()
+	if writeErr != nil {
+		return n, writeErr
+	}
+
+	return n, err
+}
+
+// Finish completes the response once the handler is done. A handler that
+// wrote nothing gets an empty 200, chunked bodies get their last chunk and
+// trailers. It returns ERROR_CONTENT_LENGTH_MISMATCH if fewer bytes than
+// announced were written, the connection can't be reused then.
+func (w *Writer) Finish() error {
+	if w.state == WriteDone {
+		return ERROR_RESPONSE_FINISHED
+	}
+
+	if w.state == WriteStatusLine {
+		if !w.headers.Contains("Content-Length") && !w.headers.Contains("Transfer-Encoding") {
+			w.headers.Set("Content-Length", "0")
+		}
+
+		if err := w.WriteHeader(HTTP_STATUS_OK); err != nil {
+			return err
+		}
+	}
+
+	if w.chunked && !w.headOnly {
+		w.state = WriteTrailers
+
+		if err := w.writeTrailers(); err != nil {
+			return err
+		}
+	}
+
+	w.state = WriteDone
+
+	if !w.chunked && !w.untilClose && !w.noBody && !w.headOnly && w.written < w.contentLength {
+		return ERROR_CONTENT_LENGTH_MISMATCH
+	}
+
+	return nil
 }
 
-func (w ResponseWriter) writeAll() {
-	w.writeStatusLine()
-	w.writeHeaders()
-	w.writeBody()
+// prepareHeaders picks the body framing for the status and headers about
+// to be written.
+func (w *Writer) prepareHeaders() error {
+	code := w.statusCode
+
+	if code == HTTP_STATUS_NO_CONTENT || code == HTTP_STATUS_NOT_MODIFIED {
+		w.noBody = true
+		w.headers.Del("Transfer-Encoding")
+
+		if code != HTTP_STATUS_NOT_MODIFIED {
+			w.headers.Del("Content-Length")
+		}
+
+		return nil
+	}
+
+	if !w.headers.Contains("Content-Type") {
+		w.headers.Set("Content-Type", "text/plain")
+	}
+
+	if w.headers.Contains("Content-Length") {
+		contentLength, err := strconv.Atoi(w.headers.Get("Content-Length"))
+
+		if err != nil || contentLength < 0 {
+			return fmt.Errorf("Invalid Content-Length header: %s", w.headers.Get("Content-Length"))
+		}
+
+		w.headers.Del("Transfer-Encoding")
+		w.contentLength = contentLength
+
+		return nil
+	}
+
+	if w.http10 {
+		w.untilClose = true
+		w.headers.Del("Transfer-Encoding")
+		w.headers.Set("Connection", "close")
+
+		return nil
+	}
+
+	w.chunked = true
+	w.headers.Set("Transfer-Encoding", "chunked")
+
+	return nil
 }
 
-func (w *ResponseWriter) writeStatusLine() error {
+func (w *Writer) writeStatusLine() error {
 	if w.state != WriteStatusLine {
 		return fmt.Errorf("Invalid state to write status line")
 	}
 
-	reasonPhrase := ""
+	reasonPhrase := StatusText(w.statusCode)
 
-	switch w.statusCode {
-	case HTTP_STATUS_OK:
-		reasonPhrase = "OK"
-	case HTTP_STATUS_BAD_REQUEST:
-		reasonPhrase = "Bad Request"
-	case HTTP_STATUS_INTERNAL_SERVER_ERROR:
-		reasonPhrase = "Internal Server Error"
+	if w.reasonPhrase != "" && isValidReasonPhrase(w.reasonPhrase) {
+		reasonPhrase = w.reasonPhrase
 	}
 
 	w.state = WriteHeaders
@@ -162,7 +344,7 @@ func (w *ResponseWriter) writeStatusLine() error {
 	return err
 }
 
-func (w *ResponseWriter) writeHeaders() error {
+func (w *Writer) writeHeaders() error {
 	if w.state != WriteHeaders {
 		return fmt.Errorf("Invalid state to write headers")
 	}
@@ -176,40 +358,31 @@ func (w *ResponseWriter) writeHeaders() error {
 	return err
 }
 
-func (w *ResponseWriter) writeBody() (int, error) {
-	if w.state != WriteBody {
-		return 0, fmt.Errorf("Invalid state to write body")
-	}
-
-	w.state = WriteDone
-
-	return w.writer.Write(w.body)
-}
+func (w *Writer) writeChunk(data []byte) (int, error) {
+	chunk := make([]byte, 0, len(data)+32)
+	chunk = fmt.Appendf(chunk, "%x\r\n", len(data))
+	chunk = append(chunk, data...)
+	chunk = append(chunk, "\r\n"...)
 
-func (w *ResponseWriter) writeBodyFromByteArray(body []byte) (int, error) {
-	if w.state != WriteBody {
-		return 0, fmt.Errorf("Invalid state to write body")
+	if _, err := w.writer.Write(chunk); err != nil {
+		return 0, err
 	}
 
-	return w.writer.Write(body)
+	w.written += len(data)
+
+	return len(data), nil
 }
 
-func (w *ResponseWriter) writeTrailers() error {
+func (w *Writer) writeTrailers() error {
 	if w.state != WriteTrailers {
 		return fmt.Errorf("Invalid state to write trailers")
 	}
 
-	if len(w.trailers.GetHeaders()) == 0 {
-		w.state = WriteDone
-		return nil
-	}
-
-	trailerString := w.trailers.ToString()
+	trailerString := "0\r\n" + w.trailers.ToString()
 
 	_, err := w.writer.Write([]byte(trailerString))
 
 	w.state = WriteDone
 
 	return err
-
 }
@@ -0,0 +1,559 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"go-http/internal/cookie"
+	"go-http/internal/headers"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func statusLine(t *testing.T, buf *bytes.Buffer) string {
+	line, _, found := strings.Cut(buf.String(), "\r\n")
+	require.True(t, found)
+
+	return line
+}
+
+func TestStatusLine(t *testing.T) {
+	// Test: registered status codes
+	assert.Equal(t, "Not Found", StatusText(HTTP_STATUS_NOT_FOUND))
+	assert.Equal(t, "HTTP Version Not Supported", StatusText(HTTP_STATUS_HTTP_VERSION_NOT_SUPPORTED))
+	assert.Equal(t, "Early Hints", StatusText(HTTP_STATUS_EARLY_HINTS))
+	assert.Equal(t, "", StatusText(299))
+
+	buf := &bytes.Buffer{}
+	SendEmptyResponse(NewResponseWriter(buf), HTTP_STATUS_IM_USED)
+	assert.Equal(t, "HTTP/1.1 226 IM Used", statusLine(t, buf))
+
+	// Test: unregistered code keeps the separator before the empty reason
+	buf = &bytes.Buffer{}
+	SendEmptyResponse(NewResponseWriter(buf), 299)
+	assert.Equal(t, "HTTP/1.1 299 ", statusLine(t, buf))
+
+	// Test: custom reason phrase
+	buf = &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	w.SetReasonPhrase("Totally Fine")
+	SendEmptyResponse(w, HTTP_STATUS_OK)
+	assert.Equal(t, "HTTP/1.1 200 Totally Fine", statusLine(t, buf))
+
+	// Test: reason phrase that would break the status line is ignored
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	w.SetReasonPhrase("OK\r\nX-Injected: yes")
+	SendEmptyResponse(w, HTTP_STATUS_OK)
+	assert.Equal(t, "HTTP/1.1 200 OK", statusLine(t, buf))
+	assert.NotContains(t, buf.String(), "X-Injected")
+
+	// Test: status codes outside of three digits are never written
+	buf = &bytes.Buffer{}
+	SendEmptyResponse(NewResponseWriter(buf), 1000)
+	assert.Empty(t, buf.String())
+}
+
+func TestWriterStateMachine(t *testing.T) {
+	// Test: status can only be written once
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	require.NoError(t, w.WriteHeader(HTTP_STATUS_CREATED))
+	assert.ErrorIs(t, w.WriteHeader(HTTP_STATUS_OK), ERROR_HEADER_ALREADY_WRITTEN)
+	require.NoError(t, w.Finish())
+	assert.ErrorIs(t, w.Finish(), ERROR_RESPONSE_FINISHED)
+
+	_, err := w.Write([]byte("late"))
+	assert.ErrorIs(t, err, ERROR_RESPONSE_FINISHED)
+
+	// Test: headers set through Header() stick and Write implies 200
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	w.Header().Set("Content-Length", "5")
+	w.Header().Set("X-Custom", "yes")
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "yes", resp.Header.Get("X-Custom"))
+	assert.Equal(t, "hello", string(body))
+
+	// Test: writing past Content-Length is cut off
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	w.Header().Set("Content-Length", "3")
+	n, err := w.Write([]byte("hello"))
+	assert.Equal(t, 3, n)
+	assert.ErrorIs(t, err, ERROR_CONTENT_LENGTH_EXCEEDED)
+
+	// Test: writing less than Content-Length is reported
+	w = NewResponseWriter(&bytes.Buffer{})
+	w.Header().Set("Content-Length", "10")
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.Finish(), ERROR_CONTENT_LENGTH_MISMATCH)
+
+	// Test: no body for 204
+	w = NewResponseWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteHeader(HTTP_STATUS_NO_CONTENT))
+	_, err = w.Write([]byte("hello"))
+	assert.ErrorIs(t, err, ERROR_BODY_NOT_ALLOWED)
+
+	// Test: handler that wrote nothing gets an empty 200
+	buf = &bytes.Buffer{}
+	require.NoError(t, NewResponseWriter(buf).Finish())
+	resp, err = http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int64(0), resp.ContentLength)
+}
+
+func TestChunkedWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+
+	require.NoError(t, SendFromStream(w, HTTP_STATUS_OK, io.NopCloser(strings.NewReader("hello world"))))
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "11", resp.Trailer.Get("X-Content-Length"))
+	assert.Equal(t,
+		"b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+		resp.Trailer.Get("X-Content-SHA256"))
+}
+
+func TestHTTP10Writer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	w.SetHTTP10()
+
+	// Test: bodies without Content-Length end with the connection
+	_, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	assert.Empty(t, resp.TransferEncoding)
+	assert.True(t, resp.Close)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Test: Content-Length is still used when set
+	buf.Reset()
+	w = NewResponseWriter(buf)
+	w.SetHTTP10()
+	require.NoError(t, SendBodyWithDefaultHeaders(w, HTTP_STATUS_OK, []byte("hi")))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Content-Length: 2\r\n")
+	assert.NotContains(t, buf.String(), "Connection")
+}
+
+func TestInterimResponses(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+
+	// Test: 103 goes out with the headers so far, the final status follows
+	w.Header().Set("Link", "</app.css>; rel=preload")
+	require.NoError(t, w.WriteHeader(HTTP_STATUS_EARLY_HINTS))
+	assert.False(t, w.HeaderWritten())
+
+	require.NoError(t, SendBodyWithDefaultHeaders(w, HTTP_STATUS_OK, []byte("done")))
+	require.NoError(t, w.Finish())
+
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 103 Early Hints\r\nLink: </app.css>; rel=preload\r\n\r\nHTTP/1.1 200 OK\r\n"))
+
+	reader := bufio.NewReader(buf)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 103, resp.StatusCode)
+
+	resp, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "done", string(body))
+
+	// Test: protocol switches can't be done through the writer
+	w = NewResponseWriter(&bytes.Buffer{})
+	assert.Equal(t, ERROR_SWITCHING_PROTOCOLS, w.WriteHeader(HTTP_STATUS_SWITCHING_PROTOCOLS))
+
+	// Test: HTTP/1.0 clients get no interim responses
+	buf.Reset()
+	w = NewResponseWriter(buf)
+	w.SetHTTP10()
+	require.NoError(t, w.WriteHeader(HTTP_STATUS_PROCESSING))
+	require.NoError(t, SendEmptyResponse(w, HTTP_STATUS_NO_CONTENT))
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 204 No Content\r\n"))
+
+	// Test: the recorder keeps the final status
+	recorder := NewStatusRecorder(NewResponseWriter(&bytes.Buffer{}))
+	require.NoError(t, recorder.WriteHeader(HTTP_STATUS_EARLY_HINTS))
+	assert.Equal(t, StatusCode(0), recorder.StatusCode())
+	require.NoError(t, recorder.WriteHeader(HTTP_STATUS_ACCEPTED))
+	assert.Equal(t, HTTP_STATUS_ACCEPTED, recorder.StatusCode())
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		value  string
+		ranges []ByteRange
+		err    error
+	}{
+		{"bytes=0-4", []ByteRange{{0, 5}}, nil},
+		{"bytes=5-", []ByteRange{{5, 5}}, nil},
+		{"bytes=-3", []ByteRange{{7, 3}}, nil},
+		{"bytes=-20", []ByteRange{{0, 10}}, nil},
+		{"bytes=8-100", []ByteRange{{8, 2}}, nil},
+		{"Bytes=0-1, 4-5", []ByteRange{{0, 2}, {4, 2}}, nil},
+		{"bytes=0-1,20-30", []ByteRange{{0, 2}}, nil},
+		{"bytes=10-", nil, ERROR_RANGE_NOT_SATISFIABLE},
+		{"bytes=-0", nil, ERROR_RANGE_NOT_SATISFIABLE},
+		{"bytes=5-2", nil, ERROR_INVALID_RANGE},
+		{"bytes=a-b", nil, ERROR_INVALID_RANGE},
+		{"bytes=+1-2", nil, ERROR_INVALID_RANGE},
+		{"items=0-1", nil, ERROR_INVALID_RANGE},
+		{"bytes=0-9,0-9", nil, ERROR_INVALID_RANGE},
+	}
+
+	for _, test := range tests {
+		ranges, err := ParseRange(test.value, 10)
+		assert.Equal(t, test.err, err, test.value)
+		assert.Equal(t, test.ranges, ranges, test.value)
+	}
+}
+
+func sendContent(t *testing.T, reqHeaders ...string) (*http.Response, string) {
+	hdrs := headers.NewHeaders()
+
+	for i := 0; i < len(reqHeaders); i += 2 {
+		hdrs.Set(reqHeaders[i], reqHeaders[i+1])
+	}
+
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("ETag", `"v1"`)
+	w.Header().Set("Last-Modified", "Wed, 01 May 2024 12:00:00 GMT")
+
+	require.NoError(t, SendContent(w, hdrs, strings.NewReader("0123456789")))
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func TestSendContent(t *testing.T) {
+	// Test: whole content
+	resp, body := sendContent(t)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, "0123456789", body)
+
+	// Test: single range
+	resp, body = sendContent(t, "Range", "bytes=2-5")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "bytes 2-5/10", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "2345", body)
+
+	// Test: several ranges
+	resp, body = sendContent(t, "Range", "bytes=0-1,-2")
+	assert.Equal(t, 206, resp.StatusCode)
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var parts []string
+
+	for {
+		part, err := reader.NextPart()
+
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+
+		assert.Equal(t, "text/plain", part.Header.Get("Content-Type"))
+		parts = append(parts, part.Header.Get("Content-Range")+" "+string(data))
+	}
+
+	assert.Equal(t, []string{"bytes 0-1/10 01", "bytes 8-9/10 89"}, parts)
+
+	// Test: unsatisfiable and ignored ranges
+	resp, _ = sendContent(t, "Range", "bytes=20-")
+	assert.Equal(t, 416, resp.StatusCode)
+	assert.Equal(t, "bytes */10", resp.Header.Get("Content-Range"))
+
+	resp, _ = sendContent(t, "Range", "lines=1-2")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: If-Range
+	resp, _ = sendContent(t, "Range", "bytes=0-1", "If-Range", `"v1"`)
+	assert.Equal(t, 206, resp.StatusCode)
+
+	resp, _ = sendContent(t, "Range", "bytes=0-1", "If-Range", `"v0"`)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, _ = sendContent(t, "Range", "bytes=0-1", "If-Range", "Wed, 01 May 2024 12:00:00 GMT")
+	assert.Equal(t, 206, resp.StatusCode)
+
+	resp, _ = sendContent(t, "Range", "bytes=0-1", "If-Range", "Thu, 02 May 2024 12:00:00 GMT")
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+// unreadable fails every Read, only seeking works.
+type unreadable struct {
+	*strings.Reader
+}
+
+func (unreadable) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestSendContentHead(t *testing.T) {
+	// Test: HEAD never reads the content, for the whole of it or ranges
+	for _, rangeValue := range []string{"", "bytes=2-5", "bytes=0-1,-2"} {
+		hdrs := headers.NewHeaders()
+
+		if rangeValue != "" {
+			hdrs.Set("Range", rangeValue)
+		}
+
+		buf := &bytes.Buffer{}
+		w := NewResponseWriter(buf)
+		w.SetHeadOnly()
+
+		require.NoError(t, SendContent(w, hdrs, unreadable{strings.NewReader("0123456789")}), rangeValue)
+		require.NoError(t, w.Finish())
+
+		resp, err := http.ReadResponse(bufio.NewReader(buf), &http.Request{Method: "HEAD"})
+		require.NoError(t, err)
+		assert.NotEqual(t, int64(-1), resp.ContentLength, rangeValue)
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+
+	require.NoError(t, SetCookie(w, &cookie.Cookie{Name: "a", Value: "1", HttpOnly: true}))
+	require.NoError(t, SetCookie(w, &cookie.Cookie{Name: "b", Value: "2", Path: "/"}))
+	assert.ErrorIs(t, SetCookie(w, &cookie.Cookie{Name: "c", Value: "x;y"}), cookie.ERROR_INVALID_VALUE)
+	require.NoError(t, SendEmptyResponse(w, HTTP_STATUS_OK))
+
+	// Test: one header line per cookie
+	assert.Contains(t, buf.String(), "Set-Cookie: a=1; HttpOnly\r\nSet-Cookie: b=2; Path=/\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	assert.Len(t, resp.Cookies(), 2)
+}
+
+func TestJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+
+	require.NoError(t, JSON(w, HTTP_STATUS_CREATED, map[string]int{"id": 7}))
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "{\"id\":7}\n", string(body))
+
+	// Test: values that can't be encoded write nothing
+	w = NewResponseWriter(&bytes.Buffer{})
+	assert.Error(t, JSON(w, HTTP_STATUS_OK, func() {}))
+	assert.False(t, w.HeaderWritten())
+}
+
+func TestProblem(t *testing.T) {
+	problem := NewProblem(HTTP_STATUS_FORBIDDEN, "Your account lacks credit.")
+	problem.Type = "https://example.test/probs/out-of-credit"
+	problem.Instance = "/account/12345"
+	problem.Extensions = map[string]any{"balance": 30, "status": 200}
+
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+
+	require.NoError(t, SendProblem(w, problem))
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+	// Test: extensions can't replace standard members
+	assert.JSONEq(t, `{
+		"type": "https://example.test/probs/out-of-credit",
+		"title": "Forbidden",
+		"status": 403,
+		"detail": "Your account lacks credit.",
+		"instance": "/account/12345",
+		"balance": 30
+	}`, string(body))
+
+	// Test: members left empty are left out
+	data, err := json.Marshal(NewProblem(HTTP_STATUS_NOT_FOUND, ""))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title": "Not Found", "status": 404}`, string(data))
+}
+
+func TestEventStream(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+
+	stream, err := NewEventStream(w)
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(Event{ID: "7", Event: "update", Data: "one\ntwo", Retry: 3 * time.Second}))
+	require.NoError(t, stream.Send(Event{Data: "plain"}))
+	require.NoError(t, stream.Comment("ping"))
+
+	// Test: fields that would end the frame early are rejected
+	assert.Equal(t, ERROR_INVALID_EVENT, stream.Send(Event{ID: "1\n2", Data: "x"}))
+	assert.Equal(t, ERROR_INVALID_EVENT, stream.Send(Event{Event: "a\rb", Data: "x"}))
+
+	stream.Close()
+	assert.Equal(t, ERROR_STREAM_CLOSED, stream.Send(Event{Data: "late"}))
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "id: 7\nevent: update\nretry: 3000\ndata: one\ndata: two\n\ndata: plain\n\n: ping\n\n", string(body))
+}
+
+// closingWriter fails every write once closed, like a connection the
+// client went away from.
+type closingWriter struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closingWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	return c.Buffer.Write(p)
+}
+
+func TestEventStreamKeepAlive(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+
+	stream, err := NewEventStream(w)
+	require.NoError(t, err)
+
+	stream.KeepAlive(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	stream.Close()
+
+	assert.Contains(t, buf.String(), ":\n\n")
+
+	// Test: a failed write ends the stream
+	conn := &closingWriter{}
+	stream, err = NewEventStream(NewResponseWriter(conn))
+	require.NoError(t, err)
+
+	conn.closed = true
+	assert.Error(t, stream.Send(Event{Data: "lost"}))
+
+	select {
+	case <-stream.Done():
+	default:
+		t.Fatal("stream not done after a failed write")
+	}
+
+	assert.ErrorIs(t, stream.Err(), io.ErrClosedPipe)
+	stream.Close()
+}
+
+func TestEventStreamStalledClient(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	headRead := make(chan struct{})
+
+	// Test: the client reads the head, then stops reading
+	go func() {
+		defer close(headRead)
+
+		reader := bufio.NewReader(client)
+
+		for {
+			line, err := reader.ReadString('\n')
+
+			if err != nil || line == "\r\n" {
+				return
+			}
+		}
+	}()
+
+	stream, err := NewEventStream(NewResponseWriter(server))
+	require.NoError(t, err)
+	<-headRead
+
+	stream.SetWriteTimeout(20 * time.Millisecond)
+	stream.KeepAlive(10 * time.Millisecond)
+
+	select {
+	case <-stream.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream not done after the client stopped reading")
+	}
+
+	assert.ErrorIs(t, stream.Err(), os.ErrDeadlineExceeded)
+	stream.Close()
+}
@@ -0,0 +1,147 @@
+package response
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"go-http/internal/cookie"
+	"go-http/internal/headers"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Send writes a complete response with hdrs added to the headers already
+// set on w and a Content-Length matching body.
+func Send(w ResponseWriter, statusCode StatusCode, hdrs headers.Headers, body []byte) error {
+	w.Header().Extend(hdrs)
+
+	return SendBodyWithDefaultHeaders(w, statusCode, body)
+}
+
+func SendBodyWithDefaultHeaders(w ResponseWriter, statusCode StatusCode, body []byte) error {
+	w.Header().Del("Transfer-Encoding")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+	if err := w.WriteHeader(statusCode); err != nil {
+		return err
+	}
+
+	_, err := w.Write(body)
+
+	if err == ERROR_BODY_NOT_ALLOWED {
+		return nil
+	}
+
+	return err
+}
+
+func SendEmptyResponse(w ResponseWriter, statusCode StatusCode) error {
+	return SendBodyWithDefaultHeaders(w, statusCode, nil)
+}
+
+// SendFromStream copies reader to a chunked body and closes it. The
+// SHA-256 and length of the body are sent as trailers.
+func SendFromStream(w ResponseWriter, statusCode StatusCode, reader io.ReadCloser) error {
+	defer reader.Close()
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Trailer", "X-Content-SHA256, X-Content-Length")
+
+	if !w.Header().Contains("Content-Type") {
+		w.Header().Set("Content-Type", "text/plain")
+	}
+
+	if err := w.WriteHeader(statusCode); err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+
+	contentLen, err := io.Copy(io.MultiWriter(w, hasher), reader)
+
+	if err != nil {
+		return err
+	}
+
+	w.Trailer().Set("X-Content-SHA256", fmt.Sprintf("%x", hasher.Sum(nil)))
+	w.Trailer().Set("X-Content-Length", strconv.FormatInt(contentLen, 10))
+
+	return nil
+}
+
+// SetCookie adds a Set-Cookie header for c, one line per cookie. Invalid
+// cookies are not sent and their error returned.
+func SetCookie(w ResponseWriter, c *cookie.Cookie) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+
+	w.Header().Add("Set-Cookie", c.String())
+
+	return nil
+}
+
+// SetReasonPhrase sets a custom reason phrase on the first writer in the
+// chain of wrapped writers that supports one.
+func SetReasonPhrase(w ResponseWriter, reasonPhrase string) {
+	for w != nil {
+		if rw, ok := w.(interface{ SetReasonPhrase(string) }); ok {
+			rw.SetReasonPhrase(reasonPhrase)
+			return
+		}
+
+		w = Unwrap(w)
+	}
+}
+
+// Flush sends data buffered by writers in the chain of wrapped writers,
+// like a compressing middleware, to the client.
+func Flush(w ResponseWriter) error {
+	for w != nil {
+		if flusher, ok := w.(interface{ Flush() error }); ok {
+			return flusher.Flush()
+		}
+
+		w = Unwrap(w)
+	}
+
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of the connection under the
+// chain of wrapped writers. Streaming responses use it to outlive the
+// server's WriteTimeout, a zero time means no deadline.
+func SetWriteDeadline(w ResponseWriter, t time.Time) error {
+	for w != nil {
+		if rw, ok := w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+			return rw.SetWriteDeadline(t)
+		}
+
+		w = Unwrap(w)
+	}
+
+	return nil
+}
+
+// HeadOnly reports whether the writer under the chain of wrapped writers
+// answers a HEAD request, so producing the body can be skipped.
+func HeadOnly(w ResponseWriter) bool {
+	for w != nil {
+		if rw, ok := w.(interface{ HeadOnly() bool }); ok {
+			return rw.HeadOnly()
+		}
+
+		w = Unwrap(w)
+	}
+
+	return false
+}
+
+// Unwrap returns the writer wrapped by w, or nil if w wraps nothing.
+func Unwrap(w ResponseWriter) ResponseWriter {
+	if wrapper, ok := w.(interface{ Unwrap() ResponseWriter }); ok {
+		return wrapper.Unwrap()
+	}
+
+	return nil
+}
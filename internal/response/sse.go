@@ -0,0 +1,238 @@
+package response
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ERROR_INVALID_EVENT = fmt.Errorf("Event ID and name can't contain CR, LF or NUL")
+var ERROR_STREAM_CLOSED = fmt.Errorf("Event stream closed")
+
+// defaultStreamWriteTimeout is how long writing one frame may take before
+// the client is considered gone.
+const defaultStreamWriteTimeout = 10 * time.Second
+
+// Event is one server-sent event. Empty fields are left out of the frame.
+type Event struct {
+	ID    string
+	Event string
+	// Data is sent as one data line per line of text
+	Data string
+	// Retry asks the client to wait this long before reconnecting
+	Retry time.Duration
+}
+
+// EventStream writes server-sent events (text/event-stream) to a response,
+// flushing every frame to the client. It is safe for concurrent use.
+type EventStream struct {
+	mu           sync.Mutex
+	w            ResponseWriter
+	err          error
+	done         chan struct{}
+	stop         chan struct{}
+	wg           sync.WaitGroup
+	closed       bool
+	interval     time.Duration
+	writeTimeout time.Duration
+}
+
+// NewEventStream sends the head of an event stream on w. The stream lasts
+// until the handler returns or the client goes away. The write deadline
+// of the connection is pushed forward before every frame, a client that
+// stops reading ends the stream.
+func NewEventStream(w ResponseWriter) (*EventStream, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Del("Content-Length")
+
+	stream := &EventStream{
+		w:            w,
+		done:         make(chan struct{}),
+		stop:         make(chan struct{}),
+		writeTimeout: defaultStreamWriteTimeout,
+	}
+
+	if err := stream.extendDeadline(); err != nil {
+		return nil, err
+	}
+
+	if err := w.WriteHeader(HTTP_STATUS_OK); err != nil {
+		return nil, err
+	}
+
+	return stream, stream.flush()
+}
+
+// SetWriteTimeout sets how long writing one frame may take, on top of the
+// keep-alive interval.
+func (s *EventStream) SetWriteTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writeTimeout = timeout
+}
+
+// Send writes event as one frame.
+func (s *EventStream) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n\x00") || strings.ContainsAny(event.Event, "\r\n\x00") {
+		return ERROR_INVALID_EVENT
+	}
+
+	var builder strings.Builder
+
+	if event.ID != "" {
+		builder.WriteString("id: " + event.ID + "\n")
+	}
+
+	if event.Event != "" {
+		builder.WriteString("event: " + event.Event + "\n")
+	}
+
+	if event.Retry > 0 {
+		builder.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	if event.Data != "" || builder.Len() == 0 {
+		data := strings.ReplaceAll(strings.ReplaceAll(event.Data, "\r\n", "\n"), "\r", "\n")
+
+		for _, line := range strings.Split(data, "\n") {
+			builder.WriteString("data: " + line + "\n")
+		}
+	}
+
+	builder.WriteString("\n")
+
+	return s.write(builder.String())
+}
+
+// Comment writes a comment line, which clients ignore. Comments keep
+// proxies from closing an idle stream.
+func (s *EventStream) Comment(text string) error {
+	var builder strings.Builder
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		builder.WriteString(": " + line + "\n")
+	}
+
+	builder.WriteString("\n")
+
+	return s.write(builder.String())
+}
+
+// KeepAlive sends an empty comment every interval until the stream is
+// closed or a write fails.
+func (s *EventStream) KeepAlive(interval time.Duration) {
+	s.mu.Lock()
+	s.interval = interval
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if s.write(":\n\n") != nil {
+					return
+				}
+
+			case <-s.stop:
+				return
+
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// Done is closed when a write fails, usually because the client
+// disconnected.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that ended the stream, if any.
+func (s *EventStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Close stops the keep-alive comments. Nothing is written after it, the
+// response ends when the handler returns.
+func (s *EventStream) Close() {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+
+	s.closed = true
+	close(s.stop)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *EventStream) write(frame string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	if s.closed {
+		return ERROR_STREAM_CLOSED
+	}
+
+	if err := s.extendDeadline(); err != nil {
+		s.fail(err)
+		return err
+	}
+
+	if _, err := s.w.Write([]byte(frame)); err != nil {
+		s.fail(err)
+		return err
+	}
+
+	return s.flushLocked()
+}
+
+func (s *EventStream) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flushLocked()
+}
+
+func (s *EventStream) flushLocked() error {
+	if err := Flush(s.w); err != nil {
+		s.fail(err)
+		return err
+	}
+
+	return nil
+}
+
+// extendDeadline gives the next frame until the keep-alive after it plus
+// the write timeout.
+func (s *EventStream) extendDeadline() error {
+	return SetWriteDeadline(s.w, time.Now().Add(s.interval+s.writeTimeout))
+}
+
+func (s *EventStream) fail(err error) {
+	s.err = err
+	close(s.done)
+}
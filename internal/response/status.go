@@ -0,0 +1,166 @@
+package response
+
+type StatusCode int
+
+// Status codes from the IANA HTTP Status Code Registry.
+const (
+	HTTP_STATUS_CONTINUE            StatusCode = 100
+	HTTP_STATUS_SWITCHING_PROTOCOLS StatusCode = 101
+	HTTP_STATUS_PROCESSING          StatusCode = 102
+	HTTP_STATUS_EARLY_HINTS         StatusCode = 103
+
+	HTTP_STATUS_OK                            StatusCode = 200
+	HTTP_STATUS_CREATED                       StatusCode = 201
+	HTTP_STATUS_ACCEPTED                      StatusCode = 202
+	HTTP_STATUS_NON_AUTHORITATIVE_INFORMATION StatusCode = 203
+	HTTP_STATUS_NO_CONTENT                    StatusCode = 204
+	HTTP_STATUS_RESET_CONTENT                 StatusCode = 205
+	HTTP_STATUS_PARTIAL_CONTENT               StatusCode = 206
+	HTTP_STATUS_MULTI_STATUS                  StatusCode = 207
+	HTTP_STATUS_ALREADY_REPORTED              StatusCode = 208
+	HTTP_STATUS_IM_USED                       StatusCode = 226
+
+	HTTP_STATUS_MULTIPLE_CHOICES   StatusCode = 300
+	HTTP_STATUS_MOVED_PERMANENTLY  StatusCode = 301
+	HTTP_STATUS_FOUND              StatusCode = 302
+	HTTP_STATUS_SEE_OTHER          StatusCode = 303
+	HTTP_STATUS_NOT_MODIFIED       StatusCode = 304
+	HTTP_STATUS_USE_PROXY          StatusCode = 305
+	HTTP_STATUS_TEMPORARY_REDIRECT StatusCode = 307
+	HTTP_STATUS_PERMANENT_REDIRECT StatusCode = 308
+
+	HTTP_STATUS_BAD_REQUEST                     StatusCode = 400
+	HTTP_STATUS_UNAUTHORIZED                    StatusCode = 401
+	HTTP_STATUS_PAYMENT_REQUIRED                StatusCode = 402
+	HTTP_STATUS_FORBIDDEN                       StatusCode = 403
+	HTTP_STATUS_NOT_FOUND                       StatusCode = 404
+	HTTP_STATUS_METHOD_NOT_ALLOWED              StatusCode = 405
+	HTTP_STATUS_NOT_ACCEPTABLE                  StatusCode = 406
+	HTTP_STATUS_PROXY_AUTHENTICATION_REQUIRED   StatusCode = 407
+	HTTP_STATUS_REQUEST_TIMEOUT                 StatusCode = 408
+	HTTP_STATUS_CONFLICT                        StatusCode = 409
+	HTTP_STATUS_GONE                            StatusCode = 410
+	HTTP_STATUS_LENGTH_REQUIRED                 StatusCode = 411
+	HTTP_STATUS_PRECONDITION_FAILED             StatusCode = 412
+	HTTP_STATUS_CONTENT_TOO_LARGE               StatusCode = 413
+	HTTP_STATUS_URI_TOO_LONG                    StatusCode = 414
+	HTTP_STATUS_UNSUPPORTED_MEDIA_TYPE          StatusCode = 415
+	HTTP_STATUS_RANGE_NOT_SATISFIABLE           StatusCode = 416
+	HTTP_STATUS_EXPECTATION_FAILED              StatusCode = 417
+	HTTP_STATUS_MISDIRECTED_REQUEST             StatusCode = 421
+	HTTP_STATUS_UNPROCESSABLE_CONTENT           StatusCode = 422
+	HTTP_STATUS_LOCKED                          StatusCode = 423
+	HTTP_STATUS_FAILED_DEPENDENCY               StatusCode = 424
+	HTTP_STATUS_TOO_EARLY                       StatusCode = 425
+	HTTP_STATUS_UPGRADE_REQUIRED                StatusCode = 426
+	HTTP_STATUS_PRECONDITION_REQUIRED           StatusCode = 428
+	HTTP_STATUS_TOO_MANY_REQUESTS               StatusCode = 429
+	HTTP_STATUS_REQUEST_HEADER_FIELDS_TOO_LARGE StatusCode = 431
+	HTTP_STATUS_UNAVAILABLE_FOR_LEGAL_REASONS   StatusCode = 451
+
+	HTTP_STATUS_INTERNAL_SERVER_ERROR           StatusCode = 500
+	HTTP_STATUS_NOT_IMPLEMENTED                 StatusCode = 501
+	HTTP_STATUS_BAD_GATEWAY                     StatusCode = 502
+	HTTP_STATUS_SERVICE_UNAVAILABLE             StatusCode = 503
+	HTTP_STATUS_GATEWAY_TIMEOUT                 StatusCode = 504
+	HTTP_STATUS_HTTP_VERSION_NOT_SUPPORTED      StatusCode = 505
+	HTTP_STATUS_VARIANT_ALSO_NEGOTIATES         StatusCode = 506
+	HTTP_STATUS_INSUFFICIENT_STORAGE            StatusCode = 507
+	HTTP_STATUS_LOOP_DETECTED                   StatusCode = 508
+	HTTP_STATUS_NOT_EXTENDED                    StatusCode = 510
+	HTTP_STATUS_NETWORK_AUTHENTICATION_REQUIRED StatusCode = 511
+)
+
+var statusText = map[StatusCode]string{
+	HTTP_STATUS_CONTINUE:            "Continue",
+	HTTP_STATUS_SWITCHING_PROTOCOLS: "Switching Protocols",
+	HTTP_STATUS_PROCESSING:          "Processing",
+	HTTP_STATUS_EARLY_HINTS:         "Early Hints",
+
+	HTTP_STATUS_OK:                            "OK",
+	HTTP_STATUS_CREATED:                       "Created",
+	HTTP_STATUS_ACCEPTED:                      "Accepted",
+	HTTP_STATUS_NON_AUTHORITATIVE_INFORMATION: "Non-Authoritative Information",
+	HTTP_STATUS_NO_CONTENT:                    "No Content",
+	HTTP_STATUS_RESET_CONTENT:                 "Reset Content",
+	HTTP_STATUS_PARTIAL_CONTENT:               "Partial Content",
+	HTTP_STATUS_MULTI_STATUS:                  "Multi-Status",
+	HTTP_STATUS_ALREADY_REPORTED:              "Already Reported",
+	HTTP_STATUS_IM_USED:                       "IM Used",
+
+	HTTP_STATUS_MULTIPLE_CHOICES:   "Multiple Choices",
+	HTTP_STATUS_MOVED_PERMANENTLY:  "Moved Permanently",
+	HTTP_STATUS_FOUND:              "Found",
+	HTTP_STATUS_SEE_OTHER:          "See Other",
+	HTTP_STATUS_NOT_MODIFIED:       "Not Modified",
+	HTTP_STATUS_USE_PROXY:          "Use Proxy",
+	HTTP_STATUS_TEMPORARY_REDIRECT: "Temporary Redirect",
+	HTTP_STATUS_PERMANENT_REDIRECT: "Permanent Redirect",
+
+	HTTP_STATUS_BAD_REQUEST:                     "Bad Request",
+	HTTP_STATUS_UNAUTHORIZED:                    "Unauthorized",
+	HTTP_STATUS_PAYMENT_REQUIRED:                "Payment Required",
+	HTTP_STATUS_FORBIDDEN:                       "Forbidden",
+	HTTP_STATUS_NOT_FOUND:                       "Not Found",
+	HTTP_STATUS_METHOD_NOT_ALLOWED:              "Method Not Allowed",
+	HTTP_STATUS_NOT_ACCEPTABLE:                  "Not Acceptable",
+	HTTP_STATUS_PROXY_AUTHENTICATION_REQUIRED:   "Proxy Authentication Required",
+	HTTP_STATUS_REQUEST_TIMEOUT:                 "Request Timeout",
+	HTTP_STATUS_CONFLICT:                        "Conflict",
+	HTTP_STATUS_GONE:                            "Gone",
+	HTTP_STATUS_LENGTH_REQUIRED:                 "Length Required",
+	HTTP_STATUS_PRECONDITION_FAILED:             "Precondition Failed",
+	HTTP_STATUS_CONTENT_TOO_LARGE:               "Content Too Large",
+	HTTP_STATUS_URI_TOO_LONG:                    "URI Too Long",
+	HTTP_STATUS_UNSUPPORTED_MEDIA_TYPE:          "Unsupported Media Type",
+	HTTP_STATUS_RANGE_NOT_SATISFIABLE:           "Range Not Satisfiable",
+	HTTP_STATUS_EXPECTATION_FAILED:              "Expectation Failed",
+	HTTP_STATUS_MISDIRECTED_REQUEST:             "Misdirected Request",
+	HTTP_STATUS_UNPROCESSABLE_CONTENT:           "Unprocessable Content",
+	HTTP_STATUS_LOCKED:                          "Locked",
+	HTTP_STATUS_FAILED_DEPENDENCY:               "Failed Dependency",
+	HTTP_STATUS_TOO_EARLY:                       "Too Early",
+	HTTP_STATUS_UPGRADE_REQUIRED:                "Upgrade Required",
+	HTTP_STATUS_PRECONDITION_REQUIRED:           "Precondition Required",
+	HTTP_STATUS_TOO_MANY_REQUESTS:               "Too Many Requests",
+	HTTP_STATUS_REQUEST_HEADER_FIELDS_TOO_LARGE: "Request Header Fields Too Large",
+	HTTP_STATUS_UNAVAILABLE_FOR_LEGAL_REASONS:   "Unavailable For Legal Reasons",
+
+	HTTP_STATUS_INTERNAL_SERVER_ERROR:           "Internal Server Error",
+	HTTP_STATUS_NOT_IMPLEMENTED:                 "Not Implemented",
+	HTTP_STATUS_BAD_GATEWAY:                     "Bad Gateway",
+	HTTP_STATUS_SERVICE_UNAVAILABLE:             "Service Unavailable",
+	HTTP_STATUS_GATEWAY_TIMEOUT:                 "Gateway Timeout",
+	HTTP_STATUS_HTTP_VERSION_NOT_SUPPORTED:      "HTTP Version Not Supported",
+	HTTP_STATUS_VARIANT_ALSO_NEGOTIATES:         "Variant Also Negotiates",
+	HTTP_STATUS_INSUFFICIENT_STORAGE:            "Insufficient Storage",
+	HTTP_STATUS_LOOP_DETECTED:                   "Loop Detected",
+	HTTP_STATUS_NOT_EXTENDED:                    "Not Extended",
+	HTTP_STATUS_NETWORK_AUTHENTICATION_REQUIRED: "Network Authentication Required",
+}
+
+// StatusText returns the registered reason phrase for code, or an empty
+// string if the code is not registered.
+func StatusText(code StatusCode) string {
+	return statusText[code]
+}
+
+// isValidStatusCode reports whether code fits the three digit status-code
+// of a status line.
+func isValidStatusCode(code StatusCode) bool {
+	return code >= 100 && code <= 999
+}
+
+// isValidReasonPhrase reports whether reason only holds characters allowed
+// in a reason-phrase: HTAB, SP, visible ASCII and obs-text (RFC 9112 4).
+func isValidReasonPhrase(reason string) bool {
+	for i := 0; i < len(reason); i++ {
+		ch := reason[i]
+
+		if ch != '\t' && ch != ' ' && (ch < 0x21 || ch == 0x7f) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,65 @@
+package router
+
+import (
+	"go-http/internal/server"
+	"slices"
+)
+
+// RouteGroup registers routes under a shared path prefix and middlewares.
+type RouteGroup struct {
+	router      *Router
+	prefix      string
+	middlewares []server.Middleware
+}
+
+// Group returns a sub-group whose routes get prefix prepended to their
+// pattern and run middlewares after the ones of g.
+func (g *RouteGroup) Group(prefix string, middlewares ...server.Middleware) *RouteGroup {
+	return &RouteGroup{
+		router:      g.router,
+		prefix:      g.prefix + prefix,
+		middlewares: append(slices.Clone(g.middlewares), middlewares...),
+	}
+}
+
+// With returns a group with the same prefix and extra middlewares, for
+// middleware on a single route: r.With(auth).Get("/admin", handler).
+func (g *RouteGroup) With(middlewares ...server.Middleware) *RouteGroup {
+	return g.Group("", middlewares...)
+}
+
+// Use adds middlewares to the routes registered on g from now on.
+func (g *RouteGroup) Use(middlewares ...server.Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// Handle registers handler for method and pattern, see Router for the
+// pattern syntax. It panics on malformed patterns and on routes registered
+// twice.
+func (g *RouteGroup) Handle(method, pattern string, handler server.Handler) {
+	if handler != nil {
+		handler = server.Chain(g.middlewares...)(handler)
+	}
+
+	g.router.handle(method, g.prefix+pattern, handler)
+}
+
+func (g *RouteGroup) Get(pattern string, handler server.Handler) {
+	g.Handle("GET", pattern, handler)
+}
+
+func (g *RouteGroup) Post(pattern string, handler server.Handler) {
+	g.Handle("POST", pattern, handler)
+}
+
+func (g *RouteGroup) Put(pattern string, handler server.Handler) {
+	g.Handle("PUT", pattern, handler)
+}
+
+func (g *RouteGroup) Patch(pattern string, handler server.Handler) {
+	g.Handle("PATCH", pattern, handler)
+}
+
+func (g *RouteGroup) Delete(pattern string, handler server.Handler) {
+	g.Handle("DELETE", pattern, handler)
+}
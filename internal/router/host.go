@@ -0,0 +1,98 @@
+package router
+
+import (
+	"fmt"
+	"go-http/internal/request"
+	"go-http/internal/response"
+	"go-http/internal/server"
+	"net"
+	"strings"
+)
+
+// HostRouter dispatches requests to handlers by their Host, for serving
+// several sites from one listener.
+//
+// Host patterns are either a name like "api.example.test", or a wildcard
+// "*.example.test" matching any subdomain but not example.test itself.
+// Exact names beat wildcards and longer wildcards beat shorter ones. Ports
+// are ignored and names are compared case-insensitively.
+type HostRouter struct {
+	hosts     map[string]server.Handler
+	wildcards map[string]server.Handler
+
+	// Default handles requests no host pattern matches, including the
+	// ones without a Host. It answers 404 unless replaced.
+	Default server.Handler
+}
+
+func NewHostRouter() *HostRouter {
+	return &HostRouter{
+		hosts:     map[string]server.Handler{},
+		wildcards: map[string]server.Handler{},
+		Default:   statusHandler(response.HTTP_STATUS_NOT_FOUND),
+	}
+}
+
+// Handle registers handler for the host pattern. It panics on empty
+// patterns and on hosts registered twice.
+func (h *HostRouter) Handle(pattern string, handler server.Handler) {
+	name := normalizeHost(pattern)
+	hosts := h.hosts
+
+	if suffix, ok := strings.CutPrefix(name, "*."); ok {
+		name = suffix
+		hosts = h.wildcards
+	}
+
+	if name == "" || strings.Contains(name, "*") || handler == nil {
+		panic(fmt.Sprintf("router: invalid host %q", pattern))
+	}
+
+	if _, ok := hosts[name]; ok {
+		panic(fmt.Sprintf("router: host %q registered twice", pattern))
+	}
+
+	hosts[name] = handler
+}
+
+// ServeHTTP is the server.Handler running the handler of the request host.
+func (h *HostRouter) ServeHTTP(w response.ResponseWriter, req *request.Request) {
+	h.match(req.Host)(w, req)
+}
+
+func (h *HostRouter) match(host string) server.Handler {
+	name := normalizeHost(host)
+
+	if name == "" {
+		return h.Default
+	}
+
+	if handler, ok := h.hosts[name]; ok {
+		return handler
+	}
+
+	for {
+		_, parent, found := strings.Cut(name, ".")
+
+		if !found {
+			return h.Default
+		}
+
+		if handler, ok := h.wildcards[parent]; ok {
+			return handler
+		}
+
+		name = parent
+	}
+}
+
+// normalizeHost strips the port and trailing dot and lowercases host.
+func normalizeHost(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"fmt"
+	"go-http/internal/headers"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	segmentStatic   segmentKind = 0
+	segmentParam    segmentKind = 1
+	segmentWildcard segmentKind = 2
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern %q must start with /", pattern)
+	}
+
+	parts := splitPath(pattern)
+	segments := make([]segment, 0, len(parts))
+	names := map[string]bool{}
+
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") && !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("pattern %q: braces must wrap a whole segment", pattern)
+			}
+
+			segments = append(segments, segment{kind: segmentStatic, value: part})
+			continue
+		}
+
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			return nil, fmt.Errorf("pattern %q: braces must wrap a whole segment", pattern)
+		}
+
+		name := part[1 : len(part)-1]
+		kind := segmentParam
+
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("pattern %q: {%s} must be the last segment", pattern, name)
+			}
+
+			name = strings.TrimSuffix(name, "...")
+			kind = segmentWildcard
+		}
+
+		if name == "" || !headers.IsValidToken([]byte(name)) || strings.ContainsAny(name, "{}") {
+			return nil, fmt.Errorf("pattern %q: invalid name in %s", pattern, part)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("pattern %q: duplicate name %s", pattern, name)
+		}
+
+		names[name] = true
+		segments = append(segments, segment{kind: kind, value: name})
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,260 @@
+package router
+
+import (
+	"fmt"
+	"go-http/internal/request"
+	"go-http/internal/response"
+	"go-http/internal/server"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Router dispatches requests to handlers by method and path pattern.
+//
+// Patterns are made of "/" separated segments. A segment is either literal
+// text, a parameter "{name}" matching exactly one segment, or a wildcard
+// "{name...}" matching the rest of the path and allowed only last. Matched
+// values are available through req.PathValue(name).
+//
+// When several patterns match, the most specific one with a handler for
+// the request method wins segment by segment: literal text beats a
+// parameter, which beats a wildcard. A 405 is only sent when no matching
+// pattern has the method, with all their methods in Allow. The order in
+// which routes are registered does not matter.
+//
+// Routes are registered through the embedded RouteGroup, so Router has the same
+// Get, Post, Group and With methods.
+type Router struct {
+	*RouteGroup
+
+	root        *node
+	middlewares []server.Middleware
+
+	// NotFound handles requests no pattern matches, MethodNotAllowed the
+	// ones whose path matches but not for their method. The Allow header
+	// is already set when MethodNotAllowed runs.
+	NotFound         server.Handler
+	MethodNotAllowed server.Handler
+}
+
+type node struct {
+	static    map[string]*node
+	param     *node
+	paramName string
+	wildcard  *node
+	handlers  map[string]server.Handler
+}
+
+func New() *Router {
+	r := &Router{
+		root:             &node{},
+		NotFound:         statusHandler(response.HTTP_STATUS_NOT_FOUND),
+		MethodNotAllowed: statusHandler(response.HTTP_STATUS_METHOD_NOT_ALLOWED),
+	}
+
+	r.RouteGroup = &RouteGroup{router: r}
+
+	return r
+}
+
+// Use adds middlewares that run for every request, including the ones
+// answered by NotFound and MethodNotAllowed. Unlike RouteGroup.Use it also
+// applies to routes registered earlier.
+func (r *Router) Use(middlewares ...server.Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// handle registers handler for method and pattern. It panics on malformed
+// patterns and on routes registered twice.
+func (r *Router) handle(method, pattern string, handler server.Handler) {
+	if method == "" || handler == nil {
+		panic(fmt.Sprintf("router: invalid route %q %q", method, pattern))
+	}
+
+	segments, err := parsePattern(pattern)
+
+	if err != nil {
+		panic(fmt.Sprintf("router: %v", err))
+	}
+
+	current := r.root
+
+	for _, seg := range segments {
+		switch seg.kind {
+		case segmentStatic:
+			if current.static == nil {
+				current.static = map[string]*node{}
+			}
+
+			if current.static[seg.value] == nil {
+				current.static[seg.value] = &node{}
+			}
+
+			current = current.static[seg.value]
+
+		case segmentParam:
+			if current.param == nil {
+				current.param = &node{paramName: seg.value}
+			}
+
+			if current.param.paramName != seg.value {
+				panic(fmt.Sprintf("router: parameter {%s} in %q conflicts with {%s}", seg.value, pattern, current.param.paramName))
+			}
+
+			current = current.param
+
+		case segmentWildcard:
+			if current.wildcard == nil {
+				current.wildcard = &node{paramName: seg.value}
+			}
+
+			if current.wildcard.paramName != seg.value {
+				panic(fmt.Sprintf("router: wildcard {%s...} in %q conflicts with {%s...}", seg.value, pattern, current.wildcard.paramName))
+			}
+
+			current = current.wildcard
+		}
+	}
+
+	if current.handlers == nil {
+		current.handlers = map[string]server.Handler{}
+	}
+
+	if _, ok := current.handlers[method]; ok {
+		panic(fmt.Sprintf("router: %s %s registered twice", method, pattern))
+	}
+
+	current.handlers[method] = handler
+}
+
+// ServeHTTP is the server.Handler running the matching route.
+func (r *Router) ServeHTTP(w response.ResponseWriter, req *request.Request) {
+	server.Chain(r.middlewares...)(r.dispatch)(w, req)
+}
+
+func (r *Router) dispatch(w response.ResponseWriter, req *request.Request) {
+	// asterisk-form and authority-form targets have no path
+	if req.URL.RawPath == "" {
+		r.NotFound(w, req)
+		return
+	}
+
+	values := map[string]string{}
+	allowed := map[string]bool{}
+	matched := r.root.match(decodeSegments(req.URL.RawPath), req.RequestLine.Method, values, allowed)
+
+	if matched == nil && len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(slices.Sorted(maps.Keys(allowed)), ", "))
+		r.MethodNotAllowed(w, req)
+		return
+	}
+
+	if matched == nil {
+		r.NotFound(w, req)
+		return
+	}
+
+	for name, value := range values {
+		req.SetPathValue(name, value)
+	}
+
+	handler, _ := matched.handler(req.RequestLine.Method)
+	handler(w, req)
+}
+
+// match finds the most specific node with a handler for method, filling
+// values with the parameters along the way. Nodes matching the path but
+// not the method add their methods to allowed.
+func (n *node) match(segments []string, method string, values map[string]string, allowed map[string]bool) *node {
+	if len(segments) == 0 {
+		if n.accepts(method, allowed) {
+			return n
+		}
+
+		// "/files/{path...}" also matches "/files/"
+		if n.wildcard != nil && n.wildcard.accepts(method, allowed) {
+			values[n.wildcard.paramName] = ""
+			return n.wildcard
+		}
+
+		return nil
+	}
+
+	seg, rest := segments[0], segments[1:]
+
+	if child := n.static[seg]; child != nil {
+		if matched := child.match(rest, method, values, allowed); matched != nil {
+			return matched
+		}
+	}
+
+	if n.param != nil && seg != "" {
+		if matched := n.param.match(rest, method, values, allowed); matched != nil {
+			values[n.param.paramName] = seg
+			return matched
+		}
+	}
+
+	if n.wildcard != nil && n.wildcard.accepts(method, allowed) {
+		values[n.wildcard.paramName] = strings.Join(segments, "/")
+		return n.wildcard
+	}
+
+	return nil
+}
+
+// handler returns the handler for method, GET routes answer HEAD unless a
+// HEAD route is registered.
+func (n *node) handler(method string) (server.Handler, bool) {
+	handler, ok := n.handlers[method]
+
+	if !ok && method == "HEAD" {
+		handler, ok = n.handlers["GET"]
+	}
+
+	return handler, ok
+}
+
+// accepts reports whether n has a handler for method, or else adds the
+// methods it does have to allowed.
+func (n *node) accepts(method string, allowed map[string]bool) bool {
+	if _, ok := n.handler(method); ok {
+		return true
+	}
+
+	for m := range n.handlers {
+		allowed[m] = true
+	}
+
+	if n.handlers["GET"] != nil {
+		allowed["HEAD"] = true
+	}
+
+	return false
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// decodeSegments splits the escaped path before decoding it, so an encoded
+// slash stays part of its segment.
+func decodeSegments(rawPath string) []string {
+	segments := splitPath(rawPath)
+
+	for i, seg := range segments {
+		if decoded, err := url.PathUnescape(seg); err == nil {
+			segments[i] = decoded
+		}
+	}
+
+	return segments
+}
+
+func statusHandler(statusCode response.StatusCode) server.Handler {
+	return func(w response.ResponseWriter, req *request.Request) {
+		response.SendBodyWithDefaultHeaders(w, statusCode, []byte(response.StatusText(statusCode)))
+	}
+}
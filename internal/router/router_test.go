@@ -0,0 +1,249 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"go-http/internal/request"
+	"go-http/internal/response"
+	"go-http/internal/server"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, r *Router, method, target string) (*http.Response, string) {
+	return serveHost(t, r.ServeHTTP, method, target, "localhost")
+}
+
+func serveHost(t *testing.T, handler server.Handler, method, target, host string) (*http.Response, string) {
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	w := response.NewResponseWriter(buf)
+
+	handler(w, req)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func reply(text string, names ...string) func(w response.ResponseWriter, req *request.Request) {
+	return func(w response.ResponseWriter, req *request.Request) {
+		body := text
+
+		for _, name := range names {
+			body += " " + name + "=" + req.PathValue(name)
+		}
+
+		response.SendBodyWithDefaultHeaders(w, response.HTTP_STATUS_OK, []byte(body))
+	}
+}
+
+func TestRouterMatching(t *testing.T) {
+	r := New()
+	r.Get("/", reply("root"))
+	r.Get("/users", reply("list"))
+	r.Post("/users", reply("create"))
+	r.Get("/users/me", reply("me"))
+	r.Get("/users/{id}", reply("user", "id"))
+	r.Get("/users/{id}/posts/{post}", reply("post", "id", "post"))
+	r.Get("/files/{path...}", reply("file", "path"))
+	r.Get("/{rest...}", reply("fallback", "rest"))
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{"GET", "/", "root"},
+		{"GET", "/users", "list"},
+		{"POST", "/users", "create"},
+		{"GET", "/users/me", "me"},
+		{"GET", "/users/42", "user id=42"},
+		{"GET", "/users/42?page=2", "user id=42"},
+		{"GET", "/users/42/posts/7", "post id=42 post=7"},
+		{"GET", "/files/a/b/c.txt", "file path=a/b/c.txt"},
+		{"GET", "/files/", "file path="},
+		{"GET", "/users/42/comments", "fallback rest=users/42/comments"},
+		{"GET", "/other", "fallback rest=other"},
+	}
+
+	for _, test := range tests {
+		resp, body := serve(t, r, test.method, test.target)
+		assert.Equal(t, 200, resp.StatusCode, test.target)
+		assert.Equal(t, test.body, body, test.target)
+	}
+}
+
+func TestRouterNotFoundAndMethodNotAllowed(t *testing.T) {
+	r := New()
+	r.Get("/users/{id}", reply("user"))
+	r.Delete("/users/{id}", reply("deleted"))
+
+	resp, _ := serve(t, r, "GET", "/posts/1")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: parameters don't match empty segments
+	resp, _ = serve(t, r, "GET", "/users/")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, _ = serve(t, r, "PUT", "/users/1")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "DELETE, GET, HEAD", resp.Header.Get("Allow"))
+
+	// Test: GET routes answer HEAD
+	resp, _ = serve(t, r, "HEAD", "/users/1")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: a less specific pattern with the method wins over a more
+	// specific one without it, Allow lists every matching pattern
+	r.Post("/users/new", reply("created"))
+
+	resp, body := serve(t, r, "GET", "/users/new")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "user", body)
+
+	_, body = serve(t, r, "POST", "/users/new")
+	assert.Equal(t, "created", body)
+
+	resp, _ = serve(t, r, "HEAD", "/users/new")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, _ = serve(t, r, "PUT", "/users/new")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "DELETE, GET, HEAD, POST", resp.Header.Get("Allow"))
+
+	// Test: custom not found handler
+	r.NotFound = reply("custom")
+	_, body = serve(t, r, "GET", "/posts/1")
+	assert.Equal(t, "custom", body)
+}
+
+func TestRouterInvalidPatterns(t *testing.T) {
+	r := New()
+	r.Get("/users/{id}", reply("user"))
+
+	assert.Panics(t, func() { r.Get("users", reply("")) })
+	assert.Panics(t, func() { r.Get("/users/{id", reply("")) })
+	assert.Panics(t, func() { r.Get("/users/x{id}", reply("")) })
+	assert.Panics(t, func() { r.Get("/{path...}/x", reply("")) })
+	assert.Panics(t, func() { r.Get("/a/{x}/{x}", reply("")) })
+	assert.Panics(t, func() { r.Get("/users/{name}", reply("")) })
+	assert.Panics(t, func() { r.Get("/users/{id}", reply("")) })
+}
+
+func tag(name string, trace *[]string) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w response.ResponseWriter, req *request.Request) {
+			*trace = append(*trace, name)
+			w.Header().Add("X-Trace", name)
+			next(w, req)
+		}
+	}
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	var trace []string
+
+	r := New()
+	r.Use(tag("global", &trace))
+	r.Get("/public", reply("public"))
+
+	api := r.Group("/api", tag("api", &trace))
+	api.Get("/users/{id}", reply("user", "id"))
+	api.With(tag("auth", &trace)).Delete("/users/{id}", reply("deleted", "id"))
+
+	admin := api.Group("/admin")
+	admin.Use(tag("admin", &trace))
+	admin.Get("/stats", reply("stats"))
+
+	resp, body := serve(t, r, "GET", "/public")
+	assert.Equal(t, "public", body)
+	assert.Equal(t, []string{"global"}, resp.Header.Values("X-Trace"))
+
+	trace = nil
+	_, body = serve(t, r, "GET", "/api/users/7")
+	assert.Equal(t, "user id=7", body)
+	assert.Equal(t, []string{"global", "api"}, trace)
+
+	trace = nil
+	_, body = serve(t, r, "DELETE", "/api/users/7")
+	assert.Equal(t, "deleted id=7", body)
+	assert.Equal(t, []string{"global", "api", "auth"}, trace)
+
+	trace = nil
+	_, body = serve(t, r, "GET", "/api/admin/stats")
+	assert.Equal(t, "stats", body)
+	assert.Equal(t, []string{"global", "api", "admin"}, trace)
+
+	// Test: router middleware also runs for unknown routes
+	trace = nil
+	resp, _ = serve(t, r, "GET", "/missing")
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Equal(t, []string{"global"}, trace)
+}
+
+func TestRouterDecodedPath(t *testing.T) {
+	r := New()
+	r.Get("/users/{name}", reply("user", "name"))
+	r.Get("/files/{path...}", reply("file", "path"))
+
+	// Test: parameters are decoded, an encoded slash stays in its segment
+	_, body := serve(t, r, "GET", "/users/jane%20doe")
+	assert.Equal(t, "user name=jane doe", body)
+
+	_, body = serve(t, r, "GET", "/users/a%2Fb")
+	assert.Equal(t, "user name=a/b", body)
+
+	// Test: dot-segments are removed before matching
+	_, body = serve(t, r, "GET", "/files/x/../users/y")
+	assert.Equal(t, "file path=users/y", body)
+
+	_, body = serve(t, r, "GET", "/files/../users/y")
+	assert.Equal(t, "user name=y", body)
+}
+
+func TestHostRouter(t *testing.T) {
+	h := NewHostRouter()
+	h.Handle("api.example.test", reply("api"))
+	h.Handle("*.example.test", reply("wildcard"))
+	h.Handle("*.static.example.test", reply("static"))
+	h.Default = reply("default")
+
+	tests := []struct {
+		target string
+		host   string
+		body   string
+	}{
+		{"/", "api.example.test", "api"},
+		{"/", "API.Example.Test:8080", "api"},
+		{"/", "api.example.test.", "api"},
+		{"/", "www.example.test", "wildcard"},
+		{"/", "a.b.example.test", "wildcard"},
+		{"/", "img.static.example.test", "static"},
+		{"/", "example.test", "default"},
+		{"/", "other.test", "default"},
+		{"/", "[::1]:80", "default"},
+		{"http://api.example.test/", "other.test", "api"},
+	}
+
+	for _, test := range tests {
+		_, body := serveHost(t, h.ServeHTTP, "GET", test.target, test.host)
+		assert.Equal(t, test.body, body, test.host)
+	}
+
+	assert.Panics(t, func() { h.Handle("api.example.test", reply("")) })
+	assert.Panics(t, func() { h.Handle("*.", reply("")) })
+	assert.Panics(t, func() { h.Handle("a.*.test", reply("")) })
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"go-http/internal/request"
+	"go-http/internal/response"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type CompressConfig struct {
+	// MinSize is the smallest body worth compressing. Bodies without a
+	// Content-Length are held back until this many bytes were written.
+	MinSize int
+
+	// ContentTypes are the media types to compress, "text/*" matches all
+	// text types
+	ContentTypes []string
+
+	// Level is the gzip and zlib compression level
+	Level int
+}
+
+func DefaultCompressConfig() CompressConfig {
+	return CompressConfig{
+		MinSize: 1024,
+		ContentTypes: []string{
+			"text/*",
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"image/svg+xml",
+		},
+		Level: gzip.DefaultCompression,
+	}
+}
+
+// Compress compresses response bodies with gzip or deflate, whichever the
+// client prefers in Accept-Encoding.
+func Compress(config CompressConfig) Middleware {
+	return func(handler Handler) Handler {
+		return func(w response.ResponseWriter, req *request.Request) {
+			// HEAD gets the same headers as GET (RFC 9110 9.3.2), the body
+			// is dropped anyway
+			cw := &compressWriter{
+				ResponseWriter: w,
+				config:         config,
+				encoding:       negotiateEncoding(req.Headers.Get("Accept-Encoding")),
+				headOnly:       req.RequestLine.Method == "HEAD",
+			}
+
+			handler(cw, req)
+			cw.close()
+		}
+	}
+}
+
+// DecodeBody undoes the Content-Encoding of request bodies, see
+// request.DecodeBody. Requests in other codings get 415 with the supported
+// ones in Accept-Encoding.
+func DecodeBody(maxSize int) Middleware {
+	return func(handler Handler) Handler {
+		return func(w response.ResponseWriter, req *request.Request) {
+			if err := req.DecodeBody(maxSize); err != nil {
+				w.Header().Set("Accept-Encoding", strings.Join(request.SUPPORTED_CONTENT_ENCODINGS, ", "))
+				response.SendEmptyResponse(w, response.HTTP_STATUS_UNSUPPORTED_MEDIA_TYPE)
+				return
+			}
+
+			handler(w, req)
+		}
+	}
+}
+
+// compressWriter decides at WriteHeader whether to compress. When the body
+// size is not known yet, the status and first writes are held back until
+// MinSize is reached or the handler returns.
+type compressWriter struct {
+	response.ResponseWriter
+	config     CompressConfig
+	encoding   string
+	headOnly   bool
+	statusCode response.StatusCode
+	started    bool
+	pending    bool
+	buf        []byte
+	encoder    io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(statusCode response.StatusCode) error {
+	if cw.started {
+		return response.ERROR_HEADER_ALREADY_WRITTEN
+	}
+
+	if statusCode < 200 {
+		return cw.ResponseWriter.WriteHeader(statusCode)
+	}
+
+	cw.started = true
+
+	if !cw.compressible(statusCode) {
+		return cw.ResponseWriter.WriteHeader(statusCode)
+	}
+
+	if !cw.Header().HasToken("Vary", "Accept-Encoding") {
+		cw.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	if cw.encoding == "" || cw.Header().Contains("Content-Encoding") {
+		return cw.ResponseWriter.WriteHeader(statusCode)
+	}
+
+	if cw.Header().Contains("Content-Length") {
+		length, err := strconv.Atoi(cw.Header().Get("Content-Length"))
+
+		if err != nil || length < cw.config.MinSize {
+			return cw.ResponseWriter.WriteHeader(statusCode)
+		}
+
+		return cw.startEncoder(statusCode)
+	}
+
+	cw.statusCode = statusCode
+	cw.pending = true
+
+	return nil
+}
+
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if !cw.started {
+		if err := cw.WriteHeader(response.HTTP_STATUS_OK); err != nil {
+			return 0, err
+		}
+	}
+
+	if cw.pending {
+		cw.buf = append(cw.buf, data...)
+
+		if len(cw.buf) < cw.config.MinSize {
+			return len(data), nil
+		}
+
+		cw.pending = false
+
+		if err := cw.startEncoder(cw.statusCode); err != nil {
+			return 0, err
+		}
+
+		if _, err := cw.encoder.Write(cw.buf); err != nil {
+			return 0, err
+		}
+
+		cw.buf = nil
+
+		return len(data), nil
+	}
+
+	if cw.encoder != nil {
+		return cw.encoder.Write(data)
+	}
+
+	return cw.ResponseWriter.Write(data)
+}
+
+// Flush ends the wait for MinSize, compressing what was held back, and
+// pushes the compressed bytes so far to the client.
+func (cw *compressWriter) Flush() error {
+	if cw.pending {
+		cw.pending = false
+
+		if err := cw.startEncoder(cw.statusCode); err != nil {
+			return err
+		}
+
+		if _, err := cw.encoder.Write(cw.buf); err != nil {
+			return err
+		}
+
+		cw.buf = nil
+	}
+
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return response.Flush(cw.ResponseWriter)
+}
+
+// close sends what was held back and ends the compressed stream.
+func (cw *compressWriter) close() error {
+	if cw.pending {
+		cw.pending = false
+
+		if err := cw.ResponseWriter.WriteHeader(cw.statusCode); err != nil {
+			return err
+		}
+
+		_, err := cw.ResponseWriter.Write(cw.buf)
+
+		if err == response.ERROR_BODY_NOT_ALLOWED {
+			return nil
+		}
+
+		return err
+	}
+
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+
+	return nil
+}
+
+func (cw *compressWriter) startEncoder(statusCode response.StatusCode) error {
+	// ranges of the compressed bytes can't be served
+	cw.Header().Del("Content-Length")
+	cw.Header().Del("Accept-Ranges")
+	cw.Header().Set("Content-Encoding", cw.encoding)
+
+	if err := cw.ResponseWriter.WriteHeader(statusCode); err != nil {
+		return err
+	}
+
+	var err error
+
+	switch {
+	case cw.headOnly:
+		cw.encoder = discardEncoder{}
+	case cw.encoding == "gzip":
+		cw.encoder, err = gzip.NewWriterLevel(cw.ResponseWriter, cw.config.Level)
+	default:
+		cw.encoder, err = zlib.NewWriterLevel(cw.ResponseWriter, cw.config.Level)
+	}
+
+	return err
+}
+
+// discardEncoder stands in for the encoder of HEAD responses, there is no
+// body to compress.
+type discardEncoder struct{}
+
+func (discardEncoder) Write(data []byte) (int, error) { return len(data), nil }
+func (discardEncoder) Close() error                   { return nil }
+
+// compressible reports whether a response with statusCode and the current
+// Content-Type is one to compress.
+func (cw *compressWriter) compressible(statusCode response.StatusCode) bool {
+	if statusCode < 200 || statusCode == response.HTTP_STATUS_NO_CONTENT ||
+		statusCode == response.HTTP_STATUS_NOT_MODIFIED || statusCode == response.HTTP_STATUS_PARTIAL_CONTENT {
+		return false
+	}
+
+	contentType := cw.Header().Get("Content-Type")
+
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	for _, pattern := range cw.config.ContentTypes {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+
+		if mediaType == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Unwrap returns the wrapped ResponseWriter.
+func (cw *compressWriter) Unwrap() response.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding value by
+// q-value, preferring gzip on ties. It returns "" if neither is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	qvalues := map[string]float64{}
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+
+			if strings.EqualFold(name, "q") {
+				parsed, err := strconv.ParseFloat(value, 64)
+
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+
+				q = parsed
+			}
+		}
+
+		if coding != "" {
+			qvalues[coding] = q
+		}
+	}
+
+	best := ""
+	bestQ := 0.0
+
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := qvalues[coding]
+
+		if !ok {
+			q = qvalues["*"]
+		}
+
+		if q > bestQ {
+			best = coding
+			bestQ = q
+		}
+	}
+
+	return best
+}
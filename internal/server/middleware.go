@@ -0,0 +1,47 @@
+package server
+
+import (
+	"go-http/internal/request"
+	"go-http/internal/response"
+	"log"
+	"time"
+)
+
+// Middleware wraps a Handler with logic that runs around it.
+type Middleware func(Handler) Handler
+
+// Chain combines middlewares into one. The first middleware is the
+// outermost, so Chain(a, b)(h) runs a, then b, then h.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(handler Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			handler = middlewares[i](handler)
+		}
+
+		return handler
+	}
+}
+
+// Logging logs the method, target, status, body size and duration of
+// every request.
+func Logging(handler Handler) Handler {
+	return func(w response.ResponseWriter, req *request.Request) {
+		start := time.Now()
+		recorder := response.NewStatusRecorder(w)
+
+		handler(recorder, req)
+
+		status := recorder.StatusCode()
+
+		if status == 0 {
+			status = response.HTTP_STATUS_OK
+		}
+
+		log.Printf("%s %s %d %dB %s",
+			req.RequestLine.Method,
+			req.RequestLine.RequestTarget,
+			status,
+			recorder.BytesWritten(),
+			time.Since(start))
+	}
+}
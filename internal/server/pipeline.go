@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxSlotBuffer and maxPipelineBuffer limit how much of the responses
+// waiting their turn is held in memory, per response and per connection.
+const maxSlotBuffer = 64 << 10
+const maxPipelineBuffer = 256 << 10
+
+// pipeline lets handlers for pipelined requests run concurrently while
+// their responses still reach the connection in request order. Only the
+// oldest unfinished response writes to the connection directly, the others
+// are buffered until every response before them is complete. A response
+// that would go over the buffer limits blocks until its turn comes.
+type pipeline struct {
+	mu     sync.Mutex
+	turn   *sync.Cond
+	conn   net.Conn
+	slots  []*pipelineSlot
+	sem    chan struct{}
+	closed bool
+	wg     sync.WaitGroup
+
+	buffered   int
+	slotLimit  int
+	totalLimit int
+
+	// idle runs once no response is in flight
+	idle func()
+}
+
+type pipelineSlot struct {
+	pipeline   *pipeline
+	buf        bytes.Buffer
+	done       bool
+	closeAfter bool
+}
+
+func newPipeline(conn net.Conn, maxInFlight int) *pipeline {
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+
+	p := &pipeline{
+		conn:       conn,
+		sem:        make(chan struct{}, maxInFlight),
+		slotLimit:  maxSlotBuffer,
+		totalLimit: maxPipelineBuffer,
+	}
+
+	p.turn = sync.NewCond(&p.mu)
+
+	return p
+}
+
+// next reserves the writer for the next response. It blocks while too many
+// responses are in flight and returns nil once the connection is closing.
+func (p *pipeline) next() *pipelineSlot {
+	p.sem <- struct{}{}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		<-p.sem
+		return nil
+	}
+
+	slot := &pipelineSlot{pipeline: p}
+
+	p.slots = append(p.slots, slot)
+	p.wg.Add(1)
+
+	return slot
+}
+
+// onIdle runs fn as soon as no response is in flight, right away if there
+// is none. Until then the connection has no read deadline. A later call
+// replaces fn, nil cancels it.
+func (p *pipeline) onIdle(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.idle = nil
+
+	if fn == nil || len(p.slots) == 0 {
+		if fn != nil {
+			fn()
+		}
+
+		return
+	}
+
+	p.conn.SetReadDeadline(time.Time{})
+	p.idle = fn
+}
+
+// wait blocks until every reserved response has finished.
+func (p *pipeline) wait() {
+	p.wg.Wait()
+}
+
+func (s *pipelineSlot) Write(data []byte) (int, error) {
+	p := s.pipeline
+
+	p.mu.Lock()
+
+	// wait for our turn rather than buffer past the limits
+	for !p.closed && p.slots[0] != s &&
+		(s.buf.Len()+len(data) > p.slotLimit || p.buffered+len(data) > p.totalLimit) {
+		p.turn.Wait()
+	}
+
+	if p.closed {
+		p.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+
+	// the head slot stays the head until it finishes itself, so it is safe
+	// to write without holding the lock
+	if p.slots[0] == s {
+		p.mu.Unlock()
+		return p.conn.Write(data)
+	}
+
+	defer p.mu.Unlock()
+
+	p.buffered += len(data)
+
+	return s.buf.Write(data)
+}
+
+// SetWriteDeadline changes the write deadline of the connection, for
+// responses streaming longer than WriteTimeout.
+func (s *pipelineSlot) SetWriteDeadline(t time.Time) error {
+	return s.pipeline.conn.SetWriteDeadline(t)
+}
+
+// finish marks the response as complete and hands the connection to the
+// next response in line. If closeAfter is set the connection is closed once
+// this response has been written.
+func (s *pipelineSlot) finish(closeAfter bool) {
+	p := s.pipeline
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.wg.Done()
+
+	defer p.turn.Broadcast()
+
+	s.done = true
+	s.closeAfter = closeAfter
+
+	for len(p.slots) > 0 && p.slots[0].done && !p.closed {
+		head := p.slots[0]
+		p.slots = p.slots[1:]
+		<-p.sem
+
+		if head.closeAfter {
+			p.closed = true
+			p.conn.Close()
+			break
+		}
+
+		if len(p.slots) > 0 && p.slots[0].buf.Len() > 0 {
+			next := p.slots[0]
+
+			if _, err := p.conn.Write(next.buf.Bytes()); err != nil {
+				p.closed = true
+				p.conn.Close()
+				break
+			}
+
+			p.buffered -= next.buf.Len()
+			next.buf.Reset()
+		}
+	}
+
+	if p.closed {
+		// release the slots that will never get to write
+		for range p.slots {
+			<-p.sem
+		}
+
+		p.slots = nil
+		p.buffered = 0
+	}
+
+	if len(p.slots) == 0 && !p.closed && p.idle != nil {
+		p.idle()
+		p.idle = nil
+	}
+}
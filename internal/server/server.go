@@ -1,21 +1,106 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"go-http/internal/request"
 	"go-http/internal/response"
+	"io"
 	"log"
 	"net"
+	"runtime/debug"
+	"sync"
+	"time"
 )
 
 type Handler func(w response.ResponseWriter, req *request.Request)
 
+type Config struct {
+	// ReadHeaderTimeout is how long a client may take to send the request
+	// line and headers, counted from the first byte of the request. Slow
+	// clients get 408 Request Timeout. Zero means ReadTimeout is used.
+	ReadHeaderTimeout time.Duration
+
+	// ReadTimeout is how long reading a whole request, body included, may
+	// take. Zero means no timeout.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is how long writing a response may take, counted from
+	// the end of the request headers. Zero means no timeout.
+	WriteTimeout time.Duration
+
+	// IdleTimeout is how long a persistent connection may wait for the
+	// next request before it is closed, counted once every response is
+	// written. Zero means ReadTimeout is used.
+	IdleTimeout time.Duration
+
+	// MaxRequestsPerConn caps the number of requests served on a single
+	// connection. Zero means no limit.
+	MaxRequestsPerConn int
+
+	// MaxPipelinedRequests is how many pipelined requests on a connection
+	// may be handled concurrently. Responses are always written in request
+	// order. Values below 1 mean requests are handled one at a time.
+	MaxPipelinedRequests int
+
+	// MaxBodySize limits the size of a request body in bytes. Requests
+	// announcing a larger Content-Length get 413, chunked bodies fail with
+	// request.ERROR_BODY_TOO_LARGE once they grow past it and get a 413 if
+	// the handler sends nothing. Zero means no limit.
+	MaxBodySize int
+
+	// MaxRequestLineLength, MaxHeaderBytes and MaxHeaderCount bound the
+	// request head, see request.Reader. Going over them gets 414 URI Too
+	// Long or 431 Request Header Fields Too Large. Zero means the request
+	// package defaults.
+	MaxRequestLineLength int
+	MaxHeaderBytes       int
+	MaxHeaderCount       int
+
+	// ErrorHandler writes the response for requests the server answers
+	// itself: requests that could not be parsed (req is nil then) and
+	// handlers that panicked before writing anything (500). Nil means an
+	// empty response with just the status.
+	ErrorHandler ErrorHandler
+}
+
+type ErrorHandler func(w response.ResponseWriter, req *request.Request, statusCode response.StatusCode)
+
+func DefaultConfig() Config {
+	return Config{
+		ReadHeaderTimeout:    10 * time.Second,
+		ReadTimeout:          60 * time.Second,
+		WriteTimeout:         60 * time.Second,
+		IdleTimeout:          120 * time.Second,
+		MaxRequestsPerConn:   100,
+		MaxPipelinedRequests: 16,
+		MaxBodySize:          10 << 20,
+	}
+}
+
 type Server struct {
-	closed  bool
-	handler Handler
+	mu       sync.Mutex
+	closed   bool
+	handler  Handler
+	config   Config
+	listener net.Listener
+	// conns maps every open connection to whether it is currently waiting
+	// for the next request
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+	// ctx is the parent of every request context, cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+	// done is closed once the server shuts down or is closed
+	done chan struct{}
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithConfig(port, handler, DefaultConfig())
+}
+
+func ServeWithConfig(port int, handler Handler, config Config) (*Server, error) {
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 
 	if err != nil {
@@ -23,8 +108,11 @@ func Serve(port int, handler Handler) (*Server, error) {
 	}
 
 	server := &Server{
-		closed:  false,
-		handler: handler,
+		closed:   false,
+		handler:  handler,
+		config:   config,
+		listener: ln,
+		conns:    map[net.Conn]bool{},
 	}
 
 	go server.listen(ln)
@@ -32,38 +120,518 @@ func Serve(port int, handler Handler) (*Server, error) {
 	return server, nil
 }
 
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the listener and closes every connection immediately,
+// including the ones with requests still in flight.
 func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.closed = true
-	return nil
+	s.closeDone()
+	s.cancelRequests()
+	err := s.listener.Close()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+
+	return err
 }
 
+// Shutdown stops accepting connections, closes the ones waiting for a new
+// request and lets in-flight requests finish. Done is closed so that
+// long-running handlers like event streams can return, request contexts
+// stay alive. If ctx is done first the remaining connections are closed
+// and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+
+	s.closed = true
+	s.closeDone()
+	err := s.listener.Close()
+
+	for conn, idle := range s.conns {
+		if idle {
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+
+		return err
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// connContext returns the context for the requests of a new connection.
+func (s *Server) connContext() (context.Context, context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+
+	return context.WithCancel(s.ctx)
+}
+
+// cancelRequests cancels every request context, s.mu must be held.
+func (s *Server) cancelRequests() {
+	if s.ctx == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+
+	s.cancel()
+}
+
+// Done is closed once Shutdown or Close is called. Handlers get it through
+// request.ServerDone.
+func (s *Server) Done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.doneChan()
+}
+
+// doneChan returns s.done, creating it first, s.mu must be held.
+func (s *Server) doneChan() chan struct{} {
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+
+	return s.done
+}
+
+// closeDone closes s.done once, s.mu must be held.
+func (s *Server) closeDone() {
+	done := s.doneChan()
+
+	select {
+	case <-done:
+	default:
+		close(done)
+	}
+}
+
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.conns[conn] = false
+	s.wg.Add(1)
+
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	s.wg.Done()
+}
+
+// waitForRequest marks conn as idle and arms its idle timeout once every
+// response in flight is written. It returns false once the server is
+// shutting down and no more requests should be read.
+func (s *Server) waitForRequest(conn net.Conn, pipeline *pipeline) bool {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+
+	s.conns[conn] = true
+	s.mu.Unlock()
+
+	pipeline.onIdle(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// Shutdown may have woken the connection up already
+		if s.closed {
+			conn.SetReadDeadline(time.Now())
+			return
+		}
+
+		setReadTimeout(conn, time.Now(), s.config.IdleTimeout, s.config.ReadTimeout)
+	})
+
+	return true
+}
+
+// readingHeaders marks conn as busy once the first byte of a request has
+// arrived and arms the header timeout.
+func (s *Server) readingHeaders(conn net.Conn, pipeline *pipeline) time.Time {
+	pipeline.onIdle(nil)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := time.Now()
+
+	s.conns[conn] = false
+	setReadTimeout(conn, start, s.config.ReadHeaderTimeout, s.config.ReadTimeout)
+
+	return start
+}
+
+// readingBody switches conn from the header timeout to the timeout for the
+// whole request and arms the write timeout for the response.
+func (s *Server) readingBody(conn net.Conn, start time.Time) {
+	setReadTimeout(conn, start, s.config.ReadTimeout, 0)
+
+	if s.config.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	}
+}
+
+// setReadTimeout sets the read deadline to start plus the first non-zero
+// timeout, or clears it if both are zero.
+func setReadTimeout(conn net.Conn, start time.Time, timeout, fallback time.Duration) {
+	if timeout == 0 {
+		timeout = fallback
+	}
+
+	if timeout == 0 {
+		conn.SetReadDeadline(time.Time{})
+		return
+	}
+
+	conn.SetReadDeadline(start.Add(timeout))
+}
+
+const minAcceptBackoff = 5 * time.Millisecond
+const maxAcceptBackoff = time.Second
+
 func (s *Server) listen(ln net.Listener) {
 	log.Println("Start listening")
 
+	var backoff time.Duration
+
 	for {
 		conn, err := ln.Accept()
 
 		if err != nil {
-			log.Fatal("TCP connection error:", err)
+			if s.shuttingDown() {
+				return
+			}
+
+			// a closed listener never accepts again
+			if errors.Is(err, net.ErrClosed) {
+				log.Println("Listener closed:", err)
+				return
+			}
+
+			// errors like running out of file descriptors pass once some
+			// connections are closed, keep trying
+			backoff = min(max(2*backoff, minAcceptBackoff), maxAcceptBackoff)
+			log.Printf("TCP connection error: %v, retrying in %v", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+
+		backoff = 0
+
+		if !s.trackConn(conn) {
+			conn.Close()
+			continue
 		}
 
-		go s.handle(conn)
+		go func() {
+			defer s.untrackConn(conn)
+			s.handle(conn)
+		}()
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
 	log.Println("handling connection")
 
-	req, err := request.RequestFromReader(conn)
+	reader := request.NewReader(conn)
+	reader.MaxBodySize = s.config.MaxBodySize
+	reader.MaxRequestLineLength = s.config.MaxRequestLineLength
+	reader.MaxHeaderBytes = s.config.MaxHeaderBytes
+	reader.MaxHeaderCount = s.config.MaxHeaderCount
+	pipeline := newPipeline(conn, s.config.MaxPipelinedRequests)
 
-	responseWriter := response.NewResponseWriter(conn)
+	// cancelled when the client goes away, on Close, or after the last
+	// handler
+	ctx, cancel := s.connContext()
+	done := s.Done()
 
-	if err != nil {
-		responseWriter.SendEmptyResponse(response.HTTP_STATUS_BAD_REQUEST)
+	defer conn.Close()
+	defer cancel()
+	defer pipeline.wait()
+	defer watchClose(conn, reader, cancel)
+
+	for served := 1; ; served++ {
+		// the next request starts after the previous body, which the
+		// handler may still be reading
+		if err := reader.WaitForBody(); err != nil {
+			return
+		}
+
+		if !s.waitForRequest(conn, pipeline) {
+			return
+		}
+
+		// the client went away, stayed idle for too long or the server is
+		// shutting down
+		if err := reader.WaitForData(); err != nil {
+			return
+		}
+
+		start := s.readingHeaders(conn, pipeline)
+
+		req, err := reader.ReadRequest()
+
+		if err != nil {
+			// nobody is left to read an error response
+			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			if s.config.WriteTimeout > 0 {
+				conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+			}
+
+			slot := pipeline.next()
+
+			if slot == nil {
+				return
+			}
+
+			responseWriter := response.NewResponseWriter(slot)
+			responseWriter.Header().Set("Connection", "close")
+			s.sendError(responseWriter, nil, errorStatus(err))
+			responseWriter.Finish()
+			slot.finish(true)
+			return
+		}
+
+		s.readingBody(conn, start)
+		req.SetContext(ctx)
+		req.SetServerDone(done)
+
+		keepAlive := s.keepAlive(req, served)
+
+		slot := pipeline.next()
+
+		if slot == nil {
+			return
+		}
+
+		go s.serve(slot, req, keepAlive)
+
+		if !keepAlive {
+			return
+		}
+	}
+}
+
+// watchClose keeps reading conn once no more requests are read from it, so
+// handlers still running learn through cancel when the client goes away.
+// Anything the client sends by then is discarded, the connection closes
+// after those handlers.
+func watchClose(conn net.Conn, reader *request.Reader, cancel context.CancelFunc) {
+	go func() {
+		// the body of the last request is read by its handler
+		reader.WaitForBody()
+
+		conn.SetReadDeadline(time.Time{})
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+}
+
+// serve runs the handler for req and completes its response in slot.
+func (s *Server) serve(slot *pipelineSlot, req *request.Request, keepAlive bool) {
+	defer req.RemoveFormFiles()
+
+	responseWriter := response.NewResponseWriter(slot)
+
+	if req.RequestLine.HttpVersion == "1.0" {
+		responseWriter.SetHTTP10()
+	}
+
+	if req.RequestLine.Method == "HEAD" {
+		responseWriter.SetHeadOnly()
+	}
+
+	req.SetContinueFunc(responseWriter.WriteContinue)
+
+	if keepAlive {
+		responseWriter.Header().Set("Connection", "keep-alive")
+	} else {
+		responseWriter.Header().Set("Connection", "close")
+	}
+
+	// a body the client was never asked to send can't be skipped, the
+	// response has to announce the close (RFC 9110 10.1.1)
+	responseWriter.BeforeWriteHeader(func() {
+		if req.WaitingForContinue() {
+			responseWriter.Header().Set("Connection", "close")
+		}
+	})
+
+	if !s.runHandler(responseWriter, req) {
+		// the handler panicked, only a response not started yet can
+		// still be replaced with an error
+		if !responseWriter.HeaderWritten() {
+			responseWriter = response.NewResponseWriter(slot)
+			responseWriter.Header().Set("Connection", "close")
+			s.sendError(responseWriter, req, response.HTTP_STATUS_INTERNAL_SERVER_ERROR)
+			responseWriter.Finish()
+		}
+
+		req.Body.Close()
+		slot.finish(true)
 		return
 	}
 
-	s.handler(*responseWriter, req)
+	// a chunked body over MaxBodySize only fails the handler's reads, the
+	// client still gets its 413 if the handler answered nothing
+	if errors.Is(req.BodyErr(), request.ERROR_BODY_TOO_LARGE) && !responseWriter.HeaderWritten() {
+		responseWriter = response.NewResponseWriter(slot)
+		responseWriter.Header().Set("Connection", "close")
+		s.sendError(responseWriter, req, response.HTTP_STATUS_CONTENT_TOO_LARGE)
+	}
+
+	req.Body.Close()
+
+	// a response cut short leaves the client unable to find the next one,
+	// and the handler may ask to close the connection
+	finishErr := responseWriter.Finish()
+	closeConn := !keepAlive || finishErr != nil || responseWriter.Header().HasToken("Connection", "close")
+
+	slot.finish(closeConn)
+}
+
+// runHandler calls the handler and recovers from its panics. It returns
+// false if the handler panicked.
+func (s *Server) runHandler(w response.ResponseWriter, req *request.Request) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("panic serving %s %s: %v\n%s",
+				req.RequestLine.Method,
+				req.RequestLine.RequestTarget,
+				v,
+				debug.Stack())
+			ok = false
+		}
+	}()
+
+	s.handler(w, req)
+
+	return true
+}
+
+// sendError answers with statusCode through the configured ErrorHandler,
+// falling back to an empty response if there is none or it panics.
+func (s *Server) sendError(w *response.Writer, req *request.Request, statusCode response.StatusCode) {
+	if s.config.ErrorHandler != nil {
+		ok := func() (ok bool) {
+			defer func() {
+				if v := recover(); v != nil {
+					log.Printf("panic in error handler: %v\n%s", v, debug.Stack())
+					ok = false
+				}
+			}()
+
+			s.config.ErrorHandler(w, req, statusCode)
+
+			return true
+		}()
+
+		if ok || w.HeaderWritten() {
+			return
+		}
+	}
+
+	response.SendEmptyResponse(w, statusCode)
+}
+
+// keepAlive decides whether the connection stays open after req. HTTP/1.0
+// connections close unless the client asks for keep-alive.
+func (s *Server) keepAlive(req *request.Request, served int) bool {
+	if req.Headers.HasToken("Connection", "close") {
+		return false
+	}
+
+	if req.RequestLine.HttpVersion == "1.0" && !req.Headers.HasToken("Connection", "keep-alive") {
+		return false
+	}
+
+	if s.shuttingDown() {
+		return false
+	}
 
-	conn.Close()
+	if s.config.MaxRequestsPerConn > 0 && served >= s.config.MaxRequestsPerConn {
+		return false
+	}
+
+	return true
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// errorStatus picks the status code sent back for a request that could
+// not be parsed.
+func errorStatus(err error) response.StatusCode {
+	switch {
+	case isTimeout(err):
+		return response.HTTP_STATUS_REQUEST_TIMEOUT
+	case errors.Is(err, request.ERROR_REQUEST_LINE_TOO_LONG):
+		return response.HTTP_STATUS_URI_TOO_LONG
+	case errors.Is(err, request.ERROR_HEADERS_TOO_LARGE):
+		return response.HTTP_STATUS_REQUEST_HEADER_FIELDS_TOO_LARGE
+	case errors.Is(err, request.ERROR_BODY_TOO_LARGE):
+		return response.HTTP_STATUS_CONTENT_TOO_LARGE
+	case errors.Is(err, request.ERROR_TRANSFER_ENCODING_NOT_SUPPORTED):
+		return response.HTTP_STATUS_NOT_IMPLEMENTED
+	case errors.Is(err, request.ERROR_METHOD_NOT_IMPLEMENTED):
+		return response.HTTP_STATUS_NOT_IMPLEMENTED
+	case errors.Is(err, request.ERROR_EXPECTATION_FAILED):
+		return response.HTTP_STATUS_EXPECTATION_FAILED
+	case errors.Is(err, request.ERROR_HTTP_VERSION_NOT_SUPPORTED):
+		return response.HTTP_STATUS_HTTP_VERSION_NOT_SUPPORTED
+	default:
+		return response.HTTP_STATUS_BAD_REQUEST
+	}
 }
@@ -0,0 +1,1012 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"go-http/internal/request"
+	"go-http/internal/response"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoTargetHandler(w response.ResponseWriter, req *request.Request) {
+	response.SendBodyWithDefaultHeaders(w, response.HTTP_STATUS_OK, []byte(req.RequestLine.RequestTarget))
+}
+
+func startConn(t *testing.T, config Config, handler Handler) (net.Conn, *bufio.Reader, chan struct{}) {
+	serverConn, clientConn := net.Pipe()
+
+	s := &Server{handler: handler, config: config, conns: map[net.Conn]bool{}}
+	done := make(chan struct{})
+
+	go func() {
+		s.handle(serverConn)
+		close(done)
+	}()
+
+	t.Cleanup(func() { clientConn.Close() })
+
+	return clientConn, bufio.NewReader(clientConn), done
+}
+
+func readResponse(t *testing.T, reader *bufio.Reader) (*http.Response, string) {
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func waitClosed(t *testing.T, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: several requests on one connection
+	conn, reader, done := startConn(t, DefaultConfig(), echoTargetHandler)
+
+	for _, target := range []string{"/one", "/two", "/three"} {
+		_, err := conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+
+		resp, body := readResponse(t, reader)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
+		assert.Equal(t, target, body)
+	}
+
+	// Test: Connection: close from the client ends the connection
+	_, err := conn.Write([]byte("GET /last HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, body := readResponse(t, reader)
+	assert.True(t, resp.Close)
+	assert.Equal(t, "/last", body)
+	waitClosed(t, done)
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 closes by default
+	conn, reader, done := startConn(t, DefaultConfig(), echoTargetHandler)
+
+	_, err := conn.Write([]byte("GET /one HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, body := readResponse(t, reader)
+	assert.True(t, resp.Close)
+	assert.Equal(t, "/one", body)
+	waitClosed(t, done)
+
+	// Test: keep-alive on request, streamed bodies close the connection
+	conn, reader, done = startConn(t, DefaultConfig(), func(w response.ResponseWriter, req *request.Request) {
+		w.Write([]byte(req.RequestLine.RequestTarget))
+	})
+
+	_, err = conn.Write([]byte("GET /two HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, body = readResponse(t, reader)
+	assert.Empty(t, resp.TransferEncoding)
+	assert.True(t, resp.Close)
+	assert.Equal(t, "/two", body)
+	waitClosed(t, done)
+}
+
+func TestHeadRequest(t *testing.T) {
+	conn, reader, _ := startConn(t, DefaultConfig(), echoTargetHandler)
+
+	_, err := conn.Write([]byte("HEAD /head HTTP/1.1\r\nHost: localhost\r\n\r\nGET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(reader, &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), resp.ContentLength)
+
+	// Test: the body was left out, the next response follows right away
+	_, body := readResponse(t, reader)
+	assert.Equal(t, "/next", body)
+}
+
+func TestRequestLineErrors(t *testing.T) {
+	for line, status := range map[string]int{
+		"BREW /pot HTTP/1.1": 501,
+		"GET / HTTP/2.0":     505,
+		"GET1@ / HTTP/1.1":   400,
+		// duplicate Host
+		"GET / HTTP/1.1\r\nHost: a": 400,
+	} {
+		conn, reader, done := startConn(t, DefaultConfig(), echoTargetHandler)
+
+		_, err := conn.Write([]byte(line + "\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+
+		resp, _ := readResponse(t, reader)
+		assert.Equal(t, status, resp.StatusCode, line)
+		waitClosed(t, done)
+	}
+}
+
+func TestMaxRequestsPerConn(t *testing.T) {
+	conn, reader, done := startConn(t, Config{MaxRequestsPerConn: 2}, echoTargetHandler)
+
+	_, err := conn.Write([]byte("GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, _ := readResponse(t, reader)
+	assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
+
+	_, err = conn.Write([]byte("GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, _ = readResponse(t, reader)
+	assert.True(t, resp.Close)
+	waitClosed(t, done)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	_, _, done := startConn(t, Config{IdleTimeout: 50 * time.Millisecond}, echoTargetHandler)
+
+	waitClosed(t, done)
+
+	// Test: the idle time starts after the response, not the request
+	conn, reader, done := startConn(t, Config{IdleTimeout: 50 * time.Millisecond}, func(w response.ResponseWriter, req *request.Request) {
+		time.Sleep(100 * time.Millisecond)
+		echoTargetHandler(w, req)
+	})
+
+	go fmt.Fprint(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	resp, body := readResponse(t, reader)
+	assert.Equal(t, "/slow", body)
+	assert.False(t, resp.Close)
+
+	go fmt.Fprint(conn, "GET /again HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	_, body = readResponse(t, reader)
+	assert.Equal(t, "/again", body)
+
+	// the connection still closes once idle after the last response
+	waitClosed(t, done)
+}
+
+func TestHandlerClosesConnection(t *testing.T) {
+	conn, reader, done := startConn(t, DefaultConfig(), func(w response.ResponseWriter, req *request.Request) {
+		w.Header().Set("Connection", "close")
+		response.SendEmptyResponse(w, response.HTTP_STATUS_OK)
+	})
+
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, _ := readResponse(t, reader)
+	assert.True(t, resp.Close)
+	waitClosed(t, done)
+}
+
+func TestPipelinedResponsesInOrder(t *testing.T) {
+	// earlier requests take longer, so handlers finish in reverse order
+	delays := map[string]time.Duration{
+		"/slow":   60 * time.Millisecond,
+		"/medium": 30 * time.Millisecond,
+		"/fast":   0,
+	}
+
+	conn, reader, done := startConn(t, DefaultConfig(), func(w response.ResponseWriter, req *request.Request) {
+		time.Sleep(delays[req.RequestLine.RequestTarget])
+		echoTargetHandler(w, req)
+	})
+
+	go conn.Write([]byte(
+		"GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /medium HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /fast HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+	))
+
+	for _, target := range []string{"/slow", "/medium", "/fast"} {
+		_, body := readResponse(t, reader)
+		assert.Equal(t, target, body)
+	}
+
+	waitClosed(t, done)
+}
+
+func TestPipelineBufferLimits(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	p := newPipeline(serverConn, 4)
+	p.slotLimit = 10
+	p.totalLimit = 15
+
+	head, second, third := p.next(), p.next(), p.next()
+
+	// Test: responses waiting their turn are buffered up to the limits
+	_, err := second.Write([]byte("0123456789"))
+	require.NoError(t, err)
+
+	_, err = third.Write([]byte("abcde"))
+	require.NoError(t, err)
+
+	// Test: going over a limit blocks the handler instead
+	written := make(chan struct{})
+
+	go func() {
+		third.Write([]byte("f"))
+		close(written)
+	}()
+
+	select {
+	case <-written:
+		t.Fatal("write went over the buffer limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.mu.Lock()
+	assert.Equal(t, 15, p.buffered)
+	p.mu.Unlock()
+
+	// the head finishing flushes the second response and frees its buffer
+	go func() {
+		head.finish(false)
+	}()
+
+	received := make([]byte, 10)
+	_, err = io.ReadFull(clientConn, received)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(received))
+
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("write not unblocked")
+	}
+
+	go func() {
+		second.finish(false)
+		third.finish(true)
+	}()
+
+	rest, err := io.ReadAll(clientConn)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", string(rest))
+}
+
+func TestPipelinedBadRequest(t *testing.T) {
+	conn, reader, done := startConn(t, DefaultConfig(), echoTargetHandler)
+
+	go conn.Write([]byte(
+		"GET /ok HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /broken HTTP/1.1\r\nHost localhost\r\n\r\n",
+	))
+
+	resp, body := readResponse(t, reader)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "/ok", body)
+
+	resp, _ = readResponse(t, reader)
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.True(t, resp.Close)
+
+	waitClosed(t, done)
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+
+	s, err := Serve(0, func(w response.ResponseWriter, req *request.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		echoTargetHandler(w, req)
+	})
+	require.NoError(t, err)
+
+	// an idle keep-alive connection must not hold up the shutdown
+	idle, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, s.Shutdown(ctx))
+
+	reader := bufio.NewReader(conn)
+	_, body := readResponse(t, reader)
+	assert.Equal(t, "/slow", body)
+
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestShutdownDeadlineClosesConnections(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	s, err := Serve(0, func(w response.ResponseWriter, req *request.Request) {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+	defer close(release)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestRequestBody(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxBodySize = 16
+
+	conn, reader, done := startConn(t, config, func(w response.ResponseWriter, req *request.Request) {
+		// read only part of the body, the server skips the rest
+		buf := make([]byte, 3)
+		n, _ := io.ReadFull(req.Body, buf)
+		response.SendBodyWithDefaultHeaders(w, response.HTTP_STATUS_OK, buf[:n])
+	})
+
+	go conn.Write([]byte(
+		"POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world" +
+			"POST /b HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nabcde" +
+			"POST /c HTTP/1.1\r\nHost: localhost\r\nContent-Length: 17\r\n\r\n",
+	))
+
+	_, body := readResponse(t, reader)
+	assert.Equal(t, "hel", body)
+
+	_, body = readResponse(t, reader)
+	assert.Equal(t, "abc", body)
+
+	resp, _ := readResponse(t, reader)
+	assert.Equal(t, 413, resp.StatusCode)
+	waitClosed(t, done)
+
+	// Test: a chunked body over the limit gets a 413 even when the handler
+	// ignores the read error
+	conn, reader, done = startConn(t, config, func(w response.ResponseWriter, req *request.Request) {
+		io.ReadAll(req.Body)
+	})
+
+	go conn.Write([]byte("POST /d HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"10\r\n0123456789abcdef\r\n5\r\nmore!\r\n0\r\n\r\n"))
+
+	resp, _ = readResponse(t, reader)
+	assert.Equal(t, 413, resp.StatusCode)
+	assert.True(t, resp.Close)
+	waitClosed(t, done)
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadHeaderTimeout = 50 * time.Millisecond
+
+	conn, reader, done := startConn(t, config, echoTargetHandler)
+
+	// half a request line and then nothing
+	_, err := conn.Write([]byte("GET /slo"))
+	require.NoError(t, err)
+
+	resp, _ := readResponse(t, reader)
+	assert.Equal(t, 408, resp.StatusCode)
+	assert.True(t, resp.Close)
+	waitClosed(t, done)
+}
+
+func TestReadTimeoutDuringBody(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadTimeout = 50 * time.Millisecond
+
+	bodyErr := make(chan error, 1)
+
+	conn, reader, done := startConn(t, config, func(w response.ResponseWriter, req *request.Request) {
+		_, err := req.ReadAll()
+		bodyErr <- err
+		response.SendEmptyResponse(w, response.HTTP_STATUS_BAD_REQUEST)
+	})
+
+	_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabc"))
+	require.NoError(t, err)
+
+	assert.True(t, isTimeout(<-bodyErr))
+
+	resp, _ := readResponse(t, reader)
+	assert.Equal(t, 400, resp.StatusCode)
+	waitClosed(t, done)
+}
+
+func TestRequestHeadLimits(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxRequestLineLength = 32
+	config.MaxHeaderCount = 2
+
+	conn, reader, done := startConn(t, config, echoTargetHandler)
+
+	go conn.Write([]byte("GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+	resp, _ := readResponse(t, reader)
+	assert.Equal(t, 414, resp.StatusCode)
+	waitClosed(t, done)
+
+	conn, reader, done = startConn(t, config, echoTargetHandler)
+
+	go conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nA: 1\r\nB: 2\r\n\r\n"))
+
+	resp, _ = readResponse(t, reader)
+	assert.Equal(t, 431, resp.StatusCode)
+	waitClosed(t, done)
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	var trace []string
+
+	step := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w response.ResponseWriter, req *request.Request) {
+				trace = append(trace, name+" before")
+				next(w, req)
+				trace = append(trace, name+" after")
+			}
+		}
+	}
+
+	handler := Chain(step("outer"), step("inner"))(func(w response.ResponseWriter, req *request.Request) {
+		trace = append(trace, "handler")
+		response.SendBodyWithDefaultHeaders(w, response.HTTP_STATUS_CREATED, []byte("hello"))
+	})
+
+	var recorder *response.StatusRecorder
+	handled := make(chan struct{})
+
+	conn, reader, _ := startConn(t, DefaultConfig(), func(w response.ResponseWriter, req *request.Request) {
+		recorder = response.NewStatusRecorder(w)
+		handler(recorder, req)
+		close(handled)
+	})
+
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, body := readResponse(t, reader)
+	<-handled
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, []string{"outer before", "inner before", "handler", "inner after", "outer after"}, trace)
+	assert.Equal(t, response.HTTP_STATUS_CREATED, recorder.StatusCode())
+	assert.Equal(t, 5, recorder.BytesWritten())
+}
+
+func TestHandlerPanic(t *testing.T) {
+	config := DefaultConfig()
+	config.ErrorHandler = func(w response.ResponseWriter, req *request.Request, statusCode response.StatusCode) {
+		w.Header().Set("Content-Type", "text/html")
+		response.SendBodyWithDefaultHeaders(w, statusCode, []byte("<h1>"+response.StatusText(statusCode)+"</h1>"))
+	}
+
+	// Test: panic before writing anything gets the error page
+	conn, reader, done := startConn(t, config, func(w response.ResponseWriter, req *request.Request) {
+		w.Header().Set("X-Partial", "yes")
+		panic("boom")
+	})
+
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, body := readResponse(t, reader)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.Equal(t, "<h1>Internal Server Error</h1>", body)
+	assert.Equal(t, "text/html", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("X-Partial"))
+	assert.True(t, resp.Close)
+	waitClosed(t, done)
+
+	// Test: panic after the response started closes the connection
+	conn, reader, done = startConn(t, config, func(w response.ResponseWriter, req *request.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("hello"))
+		panic("boom")
+	})
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	waitClosed(t, done)
+
+	// Test: bad requests go through the error handler too
+	conn, reader, done = startConn(t, config, echoTargetHandler)
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, body = readResponse(t, reader)
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.Equal(t, "<h1>Bad Request</h1>", body)
+	waitClosed(t, done)
+}
+
+func TestExpectContinue(t *testing.T) {
+	echoBody := func(w response.ResponseWriter, req *request.Request) {
+		if req.Headers.Get("X-Reject") != "" {
+			response.SendEmptyResponse(w, response.HTTP_STATUS_UNAUTHORIZED)
+			return
+		}
+
+		body, err := req.ReadAll()
+
+		if err != nil {
+			response.SendEmptyResponse(w, response.HTTP_STATUS_BAD_REQUEST)
+			return
+		}
+
+		response.SendBodyWithDefaultHeaders(w, response.HTTP_STATUS_OK, body)
+	}
+
+	// Test: 100 Continue is sent when the handler reads the body
+	conn, reader, _ := startConn(t, DefaultConfig(), echoBody)
+
+	_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n"))
+	require.NoError(t, err)
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", line)
+
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	resp, body := readResponse(t, reader)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
+
+	// Test: a handler rejecting early never asks for the body
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nX-Reject: 1\r\nContent-Length: 5\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, _ = readResponse(t, reader)
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.True(t, resp.Close)
+
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: unknown expectations
+	conn, reader, done := startConn(t, DefaultConfig(), echoBody)
+
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: magic\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+
+	resp, _ = readResponse(t, reader)
+	assert.Equal(t, 417, resp.StatusCode)
+	waitClosed(t, done)
+}
+
+func compressed(t *testing.T, acceptEncoding string, handler Handler) (*http.Response, []byte) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: " + acceptEncoding + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	config := DefaultCompressConfig()
+	config.MinSize = 100
+
+	buf := &bytes.Buffer{}
+	w := response.NewResponseWriter(buf)
+	Compress(config)(handler)(w, req)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, body
+}
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	var reader io.Reader
+	var err error
+
+	if encoding == "gzip" {
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	} else {
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	}
+
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestCompress(t *testing.T) {
+	text := strings.Repeat("compress me ", 50)
+
+	send := func(contentType, body string) Handler {
+		return func(w response.ResponseWriter, req *request.Request) {
+			w.Header().Set("Content-Type", contentType)
+			response.SendBodyWithDefaultHeaders(w, response.HTTP_STATUS_OK, []byte(body))
+		}
+	}
+
+	stream := func(body string) Handler {
+		return func(w response.ResponseWriter, req *request.Request) {
+			response.SendFromStream(w, response.HTTP_STATUS_OK, io.NopCloser(strings.NewReader(body)))
+		}
+	}
+
+	// Test: fixed-length body with the preferred encoding
+	resp, body := compressed(t, "deflate;q=0.5, gzip", send("text/html", text))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, text, decompress(t, "gzip", body))
+
+	resp, body = compressed(t, "gzip;q=0.2, deflate", send("application/json; charset=utf-8", text))
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, text, decompress(t, "deflate", body))
+
+	// Test: chunked stream, trailers still arrive
+	resp, body = compressed(t, "*", stream(text))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, text, decompress(t, "gzip", body))
+	assert.Equal(t, "600", resp.Trailer.Get("X-Content-Length"))
+
+	// Test: small bodies, other types and refused encodings stay raw
+	resp, body = compressed(t, "gzip", send("text/plain", "short"))
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, "short", string(body))
+
+	resp, body = compressed(t, "gzip", stream("short stream"))
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "short stream", string(body))
+
+	resp, body = compressed(t, "gzip", send("image/png", text))
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "", resp.Header.Get("Vary"))
+	assert.Equal(t, text, string(body))
+
+	resp, body = compressed(t, "gzip;q=0, identity", send("text/plain", text))
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, text, string(body))
+	// Test: HEAD gets the headers of GET
+	req, err := request.RequestFromReader(strings.NewReader("HEAD / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	w := response.NewResponseWriter(buf)
+	w.SetHeadOnly()
+
+	config := DefaultCompressConfig()
+	config.MinSize = 100
+	Compress(config)(send("text/html", text))(w, req)
+	require.NoError(t, w.Finish())
+
+	resp, err = http.ReadResponse(bufio.NewReader(buf), &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "", resp.Header.Get("Content-Length"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.NotContains(t, buf.String(), "\r\n\r\n0\r\n")
+}
+
+func TestDecodeBody(t *testing.T) {
+	gzipped := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzipped)
+	gw.Write([]byte("hello"))
+	gw.Close()
+
+	handler := DecodeBody(1024)(func(w response.ResponseWriter, req *request.Request) {
+		body, _ := req.ReadAll()
+		response.SendBodyWithDefaultHeaders(w, response.HTTP_STATUS_OK, body)
+	})
+
+	conn, reader, _ := startConn(t, DefaultConfig(), handler)
+
+	_, err := fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", gzipped.Len(), gzipped)
+	require.NoError(t, err)
+
+	resp, body := readResponse(t, reader)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+
+	// Test: unsupported coding
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: br\r\nContent-Length: 4\r\n\r\ndata"))
+	require.NoError(t, err)
+
+	resp, _ = readResponse(t, reader)
+	assert.Equal(t, 415, resp.StatusCode)
+	assert.Equal(t, "gzip, deflate", resp.Header.Get("Accept-Encoding"))
+}
+
+func TestEventStream(t *testing.T) {
+	stopped := make(chan struct{})
+
+	handler := func(w response.ResponseWriter, req *request.Request) {
+		defer close(stopped)
+
+		stream, err := response.NewEventStream(w)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		defer stream.Close()
+
+		stream.Send(response.Event{ID: req.LastEventID() + "1", Data: "hello"})
+
+		select {
+		case <-req.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}
+
+	// the stream goes through compression, Flush has to push every event
+	conn, reader, done := startConn(t, Config{}, Compress(DefaultCompressConfig())(handler))
+
+	go fmt.Fprint(conn, "GET /events HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nLast-Event-ID: 4\r\n\r\n")
+
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+
+	event := make([]byte, len("id: 41\ndata: hello\n\n"))
+	_, err = io.ReadFull(gz, event)
+	require.NoError(t, err)
+	assert.Equal(t, "id: 41\ndata: hello\n\n", string(event))
+
+	// Test: the request context ends when the client goes away
+	conn.Close()
+
+	select {
+	case <-stopped:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("handler did not see the client disconnect")
+	}
+
+	waitClosed(t, done)
+}
+
+// waitHandler blocks until its request context is done, reporting on
+// cancelled, or gives up after a second.
+func waitHandler(started, cancelled chan struct{}) Handler {
+	return func(w response.ResponseWriter, req *request.Request) {
+		close(started)
+
+		select {
+		case <-req.Context().Done():
+			close(cancelled)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func TestRequestContextCancelled(t *testing.T) {
+	expectCancelled := func(t *testing.T, cancelled chan struct{}) {
+		select {
+		case <-cancelled:
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("request context not cancelled")
+		}
+	}
+
+	// Test: requests the connection closes after are still watched
+	for _, head := range []string{
+		"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+		"GET / HTTP/1.0\r\n\r\n",
+	} {
+		started, cancelled := make(chan struct{}), make(chan struct{})
+		conn, _, done := startConn(t, DefaultConfig(), waitHandler(started, cancelled))
+
+		go fmt.Fprint(conn, head)
+		<-started
+		conn.Close()
+
+		expectCancelled(t, cancelled)
+		waitClosed(t, done)
+	}
+
+	// Test: the last request allowed on the connection
+	config := DefaultConfig()
+	config.MaxRequestsPerConn = 1
+
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	conn, _, done := startConn(t, config, waitHandler(started, cancelled))
+
+	go fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+	conn.Close()
+
+	expectCancelled(t, cancelled)
+	waitClosed(t, done)
+
+	// Test: a handler outliving the idle timeout
+	config = DefaultConfig()
+	config.IdleTimeout = 20 * time.Millisecond
+
+	started, cancelled = make(chan struct{}), make(chan struct{})
+	conn, _, done = startConn(t, config, waitHandler(started, cancelled))
+
+	go fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+	time.Sleep(100 * time.Millisecond)
+
+	select {
+	case <-cancelled:
+		t.Fatal("request context cancelled by the idle timeout")
+	default:
+	}
+
+	conn.Close()
+
+	expectCancelled(t, cancelled)
+	waitClosed(t, done)
+}
+
+func TestShutdownSignalsRequests(t *testing.T) {
+	started, ended := make(chan struct{}), make(chan error, 1)
+
+	s, err := Serve(0, func(w response.ResponseWriter, req *request.Request) {
+		close(started)
+
+		select {
+		case <-req.ServerDone():
+			// Test: shutting down leaves the request context alive
+			ended <- req.Context().Err()
+		case <-time.After(time.Second):
+			ended <- fmt.Errorf("server done not closed on shutdown")
+		}
+
+		response.SendEmptyResponse(w, response.HTTP_STATUS_OK)
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	require.NoError(t, s.Shutdown(ctx))
+	assert.NoError(t, <-ended)
+
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestCloseCancelsRequests(t *testing.T) {
+	started, cancelled := make(chan struct{}), make(chan struct{})
+
+	s, err := Serve(0, waitHandler(started, cancelled))
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	require.NoError(t, s.Close())
+
+	select {
+	case <-cancelled:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("request context not cancelled on close")
+	}
+}
+
+// flakyListener fails its first Accept calls, like a process out of file
+// descriptors, then hands out conns.
+type flakyListener struct {
+	failures int
+	conns    chan net.Conn
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, syscall.EMFILE
+	}
+
+	conn, ok := <-l.conns
+
+	if !ok {
+		return nil, net.ErrClosed
+	}
+
+	return conn, nil
+}
+
+func (l *flakyListener) Close() error   { return nil }
+func (l *flakyListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestAcceptErrorsAreRetried(t *testing.T) {
+	ln := &flakyListener{failures: 3, conns: make(chan net.Conn, 1)}
+	s := &Server{handler: echoTargetHandler, config: DefaultConfig(), listener: ln, conns: map[net.Conn]bool{}}
+
+	stopped := make(chan struct{})
+
+	go func() {
+		s.listen(ln)
+		close(stopped)
+	}()
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	ln.conns <- serverConn
+
+	go fmt.Fprint(clientConn, "GET /after-emfile HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	resp, body := readResponse(t, bufio.NewReader(clientConn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "/after-emfile", body)
+
+	// Test: a closed listener ends the loop
+	close(ln.conns)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("accept loop did not stop")
+	}
+}